@@ -0,0 +1,79 @@
+// Package bencode implements marshaling and unmarshaling of the bencode
+// format used throughout BitTorrent: .torrent files, tracker responses,
+// and DHT KRPC messages. Structs are (de)serialized as dicts using their
+// `bencode:"name"` tags (optionally followed by `,omitempty`); maps,
+// slices, strings and integers follow the obvious mapping.
+package bencode
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// fieldTag describes one struct field's bencode tag.
+type fieldTag struct {
+	name      string
+	omitempty bool
+	index     int
+}
+
+// tagsOf returns the bencode-tagged, exported fields of a struct type, in
+// struct declaration order.
+func tagsOf(t reflect.Type) []fieldTag {
+	var tags []fieldTag
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" { // unexported
+			continue
+		}
+		tag := f.Tag.Get("bencode")
+		if tag == "-" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		name := parts[0]
+		if name == "" {
+			name = f.Name
+		}
+		omitempty := false
+		for _, p := range parts[1:] {
+			if p == "omitempty" {
+				omitempty = true
+			}
+		}
+		tags = append(tags, fieldTag{name: name, omitempty: omitempty, index: i})
+	}
+	return tags
+}
+
+// isEmptyValue reports whether v should be skipped by an omitempty tag.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String:
+		return v.Len() == 0
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	}
+	return false
+}
+
+func sortedKeys(m map[string]reflect.Value) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var errUnsupportedType = fmt.Errorf("bencode: unsupported type")
@@ -0,0 +1,121 @@
+package bencode
+
+import (
+	"bytes"
+	"testing"
+)
+
+type nested struct {
+	Name string `bencode:"name"`
+	Len  int    `bencode:"length"`
+}
+
+type example struct {
+	Str        string `bencode:"str"`
+	Num        int64  `bencode:"num"`
+	List       []int  `bencode:"list"`
+	Bytes      []byte `bencode:"bytes"`
+	Nested     nested `bencode:"nested"`
+	Skipped    string `bencode:"-"`
+	Optional   string `bencode:"optional,omitempty"`
+	Unexported int    `bencode:"unexported"`
+}
+
+func TestMarshalUnmarshalRoundTrip(t *testing.T) {
+	in := example{
+		Str:    "hello",
+		Num:    -42,
+		List:   []int{1, 2, 3},
+		Bytes:  []byte{0xde, 0xad},
+		Nested: nested{Name: "piece", Len: 16384},
+	}
+
+	var buf bytes.Buffer
+	if err := Marshal(&buf, in); err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	var out example
+	if err := Unmarshal(&buf, &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	if out.Str != in.Str || out.Num != in.Num || out.Nested != in.Nested {
+		t.Fatalf("round trip mismatch: got %+v, want %+v", out, in)
+	}
+	if !bytes.Equal(out.Bytes, in.Bytes) {
+		t.Fatalf("Bytes round trip mismatch: got %v, want %v", out.Bytes, in.Bytes)
+	}
+	if len(out.List) != len(in.List) {
+		t.Fatalf("List round trip mismatch: got %v, want %v", out.List, in.List)
+	}
+	for i := range in.List {
+		if out.List[i] != in.List[i] {
+			t.Fatalf("List round trip mismatch: got %v, want %v", out.List, in.List)
+		}
+	}
+}
+
+func TestMarshalOmitempty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Marshal(&buf, example{Str: "x"}); err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("optional")) {
+		t.Fatalf("expected omitempty field to be dropped, got %q", buf.String())
+	}
+}
+
+func TestMarshalDictKeysSorted(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Marshal(&buf, map[string]int{"b": 2, "a": 1}); err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	want := "d1:ai1e1:bi2ee"
+	if buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestUnmarshalDict(t *testing.T) {
+	raw := "d4:name5:piece6:lengthi16384ee"
+	var n nested
+	if err := Unmarshal(bytes.NewReader([]byte(raw)), &n); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if n.Name != "piece" || n.Len != 16384 {
+		t.Fatalf("got %+v", n)
+	}
+}
+
+func TestUnmarshalList(t *testing.T) {
+	raw := "li1ei2ei3ee"
+	var out []int
+	if err := Unmarshal(bytes.NewReader([]byte(raw)), &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if len(out) != 3 || out[0] != 1 || out[1] != 2 || out[2] != 3 {
+		t.Fatalf("got %v", out)
+	}
+}
+
+func TestUnmarshalRejectsNonPointer(t *testing.T) {
+	var out int
+	if err := Unmarshal(bytes.NewReader([]byte("i1e")), out); err == nil {
+		t.Fatal("expected error for non-pointer target")
+	}
+}
+
+func TestUnmarshalTypeMismatch(t *testing.T) {
+	var out int
+	if err := Unmarshal(bytes.NewReader([]byte("3:abc")), &out); err == nil {
+		t.Fatal("expected error unmarshaling a string into an int")
+	}
+}
+
+func TestUnmarshalMalformedInput(t *testing.T) {
+	var out interface{}
+	if err := Unmarshal(bytes.NewReader([]byte("x")), &out); err == nil {
+		t.Fatal("expected error for unrecognized token")
+	}
+}
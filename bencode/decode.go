@@ -0,0 +1,222 @@
+// Description: Bencode unmarshaling: parse into a generic tree of
+// string/int64/[]interface{}/map[string]interface{}, then assign that tree
+// into the caller's struct/map/slice via reflection.
+package bencode
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+)
+
+// Unmarshal reads one bencoded value from r into v, which must be a
+// pointer.
+func Unmarshal(r io.Reader, v interface{}) error {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	raw, err := parseValue(br)
+	if err != nil {
+		return err
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("bencode: Unmarshal target must be a non-nil pointer")
+	}
+	return assign(rv.Elem(), raw)
+}
+
+// parseValue parses a single bencoded value, returning string, int64,
+// []interface{}, or map[string]interface{}.
+func parseValue(r *bufio.Reader) (interface{}, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case b[0] == 'i':
+		return parseInt(r)
+	case b[0] == 'l':
+		return parseList(r)
+	case b[0] == 'd':
+		return parseDict(r)
+	case b[0] >= '0' && b[0] <= '9':
+		return parseString(r)
+	default:
+		return nil, fmt.Errorf("bencode: unexpected token %q", b[0])
+	}
+}
+
+func parseInt(r *bufio.Reader) (int64, error) {
+	if _, err := r.ReadByte(); err != nil { // 'i'
+		return 0, err
+	}
+	tok, err := r.ReadString('e')
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(tok[:len(tok)-1], 10, 64)
+}
+
+func parseString(r *bufio.Reader) (string, error) {
+	lenTok, err := r.ReadString(':')
+	if err != nil {
+		return "", err
+	}
+	n, err := strconv.Atoi(lenTok[:len(lenTok)-1])
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func parseList(r *bufio.Reader) ([]interface{}, error) {
+	if _, err := r.ReadByte(); err != nil { // 'l'
+		return nil, err
+	}
+	var out []interface{}
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] == 'e' {
+			r.ReadByte()
+			return out, nil
+		}
+		v, err := parseValue(r)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+}
+
+func parseDict(r *bufio.Reader) (map[string]interface{}, error) {
+	if _, err := r.ReadByte(); err != nil { // 'd'
+		return nil, err
+	}
+	out := make(map[string]interface{})
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] == 'e' {
+			r.ReadByte()
+			return out, nil
+		}
+		key, err := parseString(r)
+		if err != nil {
+			return nil, err
+		}
+		val, err := parseValue(r)
+		if err != nil {
+			return nil, err
+		}
+		out[key] = val
+	}
+}
+
+// assign copies a parsed bencode tree into dst, a settable reflect.Value.
+func assign(dst reflect.Value, raw interface{}) error {
+	switch dst.Kind() {
+	case reflect.Ptr:
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return assign(dst.Elem(), raw)
+
+	case reflect.Interface:
+		dst.Set(reflect.ValueOf(raw))
+		return nil
+
+	case reflect.String:
+		s, ok := raw.(string)
+		if !ok {
+			return fmt.Errorf("bencode: expected string, got %T", raw)
+		}
+		dst.SetString(s)
+		return nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := raw.(int64)
+		if !ok {
+			return fmt.Errorf("bencode: expected integer, got %T", raw)
+		}
+		dst.SetInt(n)
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := raw.(int64)
+		if !ok {
+			return fmt.Errorf("bencode: expected integer, got %T", raw)
+		}
+		dst.SetUint(uint64(n))
+		return nil
+
+	case reflect.Slice:
+		if dst.Type().Elem().Kind() == reflect.Uint8 {
+			s, ok := raw.(string)
+			if !ok {
+				return fmt.Errorf("bencode: expected byte string, got %T", raw)
+			}
+			dst.SetBytes([]byte(s))
+			return nil
+		}
+		list, ok := raw.([]interface{})
+		if !ok {
+			return fmt.Errorf("bencode: expected list, got %T", raw)
+		}
+		out := reflect.MakeSlice(dst.Type(), len(list), len(list))
+		for i, item := range list {
+			if err := assign(out.Index(i), item); err != nil {
+				return err
+			}
+		}
+		dst.Set(out)
+		return nil
+
+	case reflect.Map:
+		dict, ok := raw.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("bencode: expected dict, got %T", raw)
+		}
+		out := reflect.MakeMapWithSize(dst.Type(), len(dict))
+		for k, v := range dict {
+			elem := reflect.New(dst.Type().Elem()).Elem()
+			if err := assign(elem, v); err != nil {
+				return err
+			}
+			out.SetMapIndex(reflect.ValueOf(k), elem)
+		}
+		dst.Set(out)
+		return nil
+
+	case reflect.Struct:
+		dict, ok := raw.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("bencode: expected dict, got %T", raw)
+		}
+		for _, tag := range tagsOf(dst.Type()) {
+			v, ok := dict[tag.name]
+			if !ok {
+				continue
+			}
+			if err := assign(dst.Field(tag.index), v); err != nil {
+				return fmt.Errorf("bencode: field %q: %w", tag.name, err)
+			}
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("%w: %s", errUnsupportedType, dst.Kind())
+	}
+}
@@ -0,0 +1,108 @@
+// Description: Bencode marshaling via reflection.
+package bencode
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Marshal writes v to w in bencode form. v (or the value a pointer/interface
+// points to) must be a struct, map[string]T, slice, string, []byte, or an
+// integer type.
+func Marshal(w io.Writer, v interface{}) error {
+	return encodeValue(w, reflect.ValueOf(v))
+}
+
+func encodeValue(w io.Writer, v reflect.Value) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return fmt.Errorf("bencode: cannot encode nil %s", v.Kind())
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		return encodeStruct(w, v)
+	case reflect.Map:
+		return encodeMap(w, v)
+	case reflect.String:
+		return encodeBytes(w, []byte(v.String()))
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return encodeBytes(w, b)
+		}
+		return encodeList(w, v)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		_, err := fmt.Fprintf(w, "i%de", v.Int())
+		return err
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		_, err := fmt.Fprintf(w, "i%de", v.Uint())
+		return err
+	default:
+		return fmt.Errorf("%w: %s", errUnsupportedType, v.Kind())
+	}
+}
+
+func encodeBytes(w io.Writer, b []byte) error {
+	if _, err := fmt.Fprintf(w, "%d:", len(b)); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func encodeList(w io.Writer, v reflect.Value) error {
+	if _, err := io.WriteString(w, "l"); err != nil {
+		return err
+	}
+	for i := 0; i < v.Len(); i++ {
+		if err := encodeValue(w, v.Index(i)); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "e")
+	return err
+}
+
+func encodeStruct(w io.Writer, v reflect.Value) error {
+	fields := map[string]reflect.Value{}
+	for _, tag := range tagsOf(v.Type()) {
+		fv := v.Field(tag.index)
+		if tag.omitempty && isEmptyValue(fv) {
+			continue
+		}
+		fields[tag.name] = fv
+	}
+	return encodeDict(w, fields)
+}
+
+func encodeMap(w io.Writer, v reflect.Value) error {
+	if v.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("bencode: map key type %s unsupported, want string", v.Type().Key())
+	}
+	fields := map[string]reflect.Value{}
+	for _, k := range v.MapKeys() {
+		fields[k.String()] = v.MapIndex(k)
+	}
+	return encodeDict(w, fields)
+}
+
+func encodeDict(w io.Writer, fields map[string]reflect.Value) error {
+	if _, err := io.WriteString(w, "d"); err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(fields) {
+		if err := encodeBytes(w, []byte(key)); err != nil {
+			return err
+		}
+		if err := encodeValue(w, fields[key]); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "e")
+	return err
+}
@@ -0,0 +1,27 @@
+// Package bitfield is the wire-format bitfield BitTorrent peers exchange to
+// advertise which pieces they have: one bit per piece, MSB first within
+// each byte, indexed from piece 0.
+package bitfield
+
+// Bitfield represents which pieces a peer has.
+type Bitfield []byte
+
+// HasPiece reports whether the bitfield has the given piece index set.
+func (bf Bitfield) HasPiece(index int) bool {
+	byteIndex := index / 8
+	offset := index % 8
+	if byteIndex < 0 || byteIndex >= len(bf) {
+		return false
+	}
+	return bf[byteIndex]>>(7-offset)&1 != 0
+}
+
+// SetPiece sets the given piece index in the bitfield.
+func (bf Bitfield) SetPiece(index int) {
+	byteIndex := index / 8
+	offset := index % 8
+	if byteIndex < 0 || byteIndex >= len(bf) {
+		return
+	}
+	bf[byteIndex] |= 1 << (7 - offset)
+}
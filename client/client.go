@@ -0,0 +1,231 @@
+// Package client implements the peer wire protocol (BEP 3): dialing a peer,
+// exchanging handshakes (optionally behind MSE/PE), and the choke/interested
+// state machine and message helpers that peer2peer.Torrent drives.
+package client
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"bit-torrent/bitfield"
+	"bit-torrent/peers"
+)
+
+// Client holds the connection state and metadata for one peer.
+type Client struct {
+	Conn io.ReadWriter
+	// Choked reports whether the peer is choking us, set by Read on receipt
+	// of a Choke/Unchoke message from them. It says nothing about whether
+	// we're choking them — see AmChoking for that.
+	Choked bool
+	// AmChoking reports whether we're choking the peer, set by
+	// SendChoke/SendUnchoke. A peer we never request anything from (e.g.
+	// one we're purely seeding to) has no reason to ever send us a
+	// Choke/Unchoke of their own, so Choked alone can't answer "should we
+	// serve this peer's requests?" — AmChoking can.
+	AmChoking bool
+	Bitfield  bitfield.Bitfield
+	peer      peers.Peer
+	infoHash  [20]byte
+	peerID    [20]byte
+
+	downloaded int64
+	uploaded   int64
+}
+
+// New dials peer, negotiates encryption per cfg if requested, completes the
+// BEP 3 handshake, and reads the peer's bitfield (if sent immediately, as
+// most clients do). The caller owns the returned Client's lifetime; close
+// its Conn when done with it.
+func New(peer peers.Peer, peerID, infoHash [20]byte, cfg Config) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", peer.String(), 3*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	handshakeMsg := newHandshake(infoHash, peerID).Serialize()
+	rw, err := negotiateEncryption(conn, infoHash, handshakeMsg, cfg)
+	if err != nil {
+		conn.Close()
+		if cfg.Encryption != EncryptionPrefer {
+			return nil, err
+		}
+
+		// Negotiation already wrote DH key-exchange bytes on the now-closed
+		// conn, so the peer is expecting an MSE stream there; a plaintext
+		// handshake can only be retried on a fresh connection, not appended
+		// to that one.
+		conn, err = net.DialTimeout("tcp", peer.String(), 3*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := conn.Write(handshakeMsg); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		rw = conn
+	}
+
+	res, err := readHandshake(rw)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if res.InfoHash != infoHash {
+		conn.Close()
+		return nil, fmt.Errorf("client: expected infohash %x, got %x", infoHash, res.InfoHash)
+	}
+
+	c := &Client{
+		Conn:      &connCloser{rw, conn},
+		Choked:    true,
+		AmChoking: true,
+		Bitfield:  bitfield.Bitfield{},
+		peer:      peer,
+		infoHash:  infoHash,
+		peerID:    peerID,
+	}
+
+	if err := c.receiveBitfield(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// connCloser pairs an io.ReadWriter (possibly an MSE stream wrapping conn)
+// with the underlying net.Conn so Close always closes the real socket
+// regardless of how many layers of encryption wrap it.
+type connCloser struct {
+	io.ReadWriter
+	net.Conn
+}
+
+func (c *connCloser) Read(p []byte) (int, error)  { return c.ReadWriter.Read(p) }
+func (c *connCloser) Write(p []byte) (int, error) { return c.ReadWriter.Write(p) }
+func (c *connCloser) Close() error                { return c.Conn.Close() }
+
+// receiveBitfield reads the peer's opening BITFIELD message, if any. Peers
+// with no pieces may skip it entirely, so this is best-effort rather than
+// required.
+func (c *Client) receiveBitfield() error {
+	msg, err := readMessage(c.Conn)
+	if err != nil {
+		return err
+	}
+	if msg == nil || msg.ID != MsgBitfield {
+		return nil
+	}
+	c.Bitfield = msg.Payload
+	return nil
+}
+
+// Peer returns the address this client is connected to.
+func (c *Client) Peer() peers.Peer {
+	return c.peer
+}
+
+// String identifies the client by its peer address, for logging.
+func (c *Client) String() string {
+	return c.peer.String()
+}
+
+// Read reads and returns a message from the peer, updating local state
+// (bitfield, choke status, download counters) for the message kinds the
+// client itself needs to track.
+func (c *Client) Read() (*Message, error) {
+	msg, err := readMessage(c.Conn)
+	if err != nil {
+		return nil, err
+	}
+	if msg == nil {
+		return nil, nil
+	}
+
+	switch msg.ID {
+	case MsgChoke:
+		c.Choked = true
+	case MsgUnchoke:
+		c.Choked = false
+	case MsgHave:
+		index, err := parseHave(msg)
+		if err != nil {
+			return nil, err
+		}
+		c.Bitfield.SetPiece(index)
+	case MsgBitfield:
+		c.Bitfield = msg.Payload
+	case MsgPiece:
+		c.downloaded += int64(len(msg.Payload) - 8)
+	}
+
+	return msg, nil
+}
+
+// SendRequest requests a block of a piece from the peer.
+func (c *Client) SendRequest(index, begin, length int) error {
+	_, err := c.Conn.Write(formatRequest(index, begin, length).Serialize())
+	return err
+}
+
+// SendInterested tells the peer we want pieces it has.
+func (c *Client) SendInterested() error {
+	_, err := c.Conn.Write((&Message{ID: MsgInterested}).Serialize())
+	return err
+}
+
+// SendNotInterested tells the peer we no longer want pieces from it.
+func (c *Client) SendNotInterested() error {
+	_, err := c.Conn.Write((&Message{ID: MsgNotInterested}).Serialize())
+	return err
+}
+
+// SendUnchoke allows the peer to request pieces from us.
+func (c *Client) SendUnchoke() error {
+	if _, err := c.Conn.Write((&Message{ID: MsgUnchoke}).Serialize()); err != nil {
+		return err
+	}
+	c.AmChoking = false
+	return nil
+}
+
+// SendChoke stops the peer from requesting pieces from us.
+func (c *Client) SendChoke() error {
+	if _, err := c.Conn.Write((&Message{ID: MsgChoke}).Serialize()); err != nil {
+		return err
+	}
+	c.AmChoking = true
+	return nil
+}
+
+// SendHave announces that we finished downloading and verified a piece.
+func (c *Client) SendHave(index int) error {
+	_, err := c.Conn.Write(formatHave(index).Serialize())
+	return err
+}
+
+// SendCancel withdraws a previously sent request, e.g. in endgame mode once
+// another peer has already delivered the block.
+func (c *Client) SendCancel(index, begin, length int) error {
+	_, err := c.Conn.Write(formatCancel(index, begin, length).Serialize())
+	return err
+}
+
+// SendKeepAlive sends a zero-length keep-alive message.
+func (c *Client) SendKeepAlive() error {
+	_, err := c.Conn.Write((*Message)(nil).Serialize())
+	return err
+}
+
+// SendPiece sends a requested block to the peer, e.g. while seeding, and
+// counts it toward the upload total seeder.ChokeManager ranks peers by.
+func (c *Client) SendPiece(index, begin int, block []byte) error {
+	if _, err := c.Conn.Write(formatPiece(index, begin, block).Serialize()); err != nil {
+		return err
+	}
+	c.uploaded += int64(len(block))
+	return nil
+}
@@ -0,0 +1,70 @@
+// Description: Connection policy for client.New, currently just whether to
+// negotiate Message Stream Encryption (MSE/PE) on outgoing connections.
+package client
+
+import (
+	"fmt"
+	"io"
+	"net"
+
+	"bit-torrent/mse"
+)
+
+// EncryptionPolicy controls whether client.New negotiates MSE/PE before the
+// standard BitTorrent handshake.
+type EncryptionPolicy int
+
+const (
+	// EncryptionPrefer negotiates encryption when the peer supports it but
+	// falls back to a plaintext handshake otherwise. This is the default.
+	EncryptionPrefer EncryptionPolicy = iota
+	// EncryptionRequire refuses to fall back to plaintext, for peers or
+	// networks that mandate encrypted connections.
+	EncryptionRequire
+	// EncryptionDisable never negotiates MSE, for debugging or networks
+	// where the obfuscation itself is the thing getting blocked.
+	EncryptionDisable
+)
+
+// Config holds per-client connection policy. The zero value is
+// EncryptionPrefer, matching prior behavior of always speaking plaintext
+// when the peer doesn't support encryption.
+type Config struct {
+	Encryption EncryptionPolicy
+}
+
+// negotiateEncryption wraps conn in an MSE stream per cfg before the
+// standard handshake is written to it. handshakeMsg is sent as MSE's
+// initial payload (IA) so encryption negotiation costs no extra round
+// trip; callers that get back the same io.ReadWriter they passed in can
+// treat plaintext and encrypted connections identically from then on.
+//
+// On failure with EncryptionPrefer, the caller cannot just fall back to
+// writing handshakeMsg to conn directly: by the time Negotiate returns an
+// error, DH key-exchange bytes have already gone out on conn, so the peer
+// on the other end is mid-MSE-handshake and would choke on a plaintext
+// BitTorrent handshake arriving instead. New handles that by redialing a
+// fresh connection and speaking plaintext there from scratch; this
+// function just reports the failure.
+func negotiateEncryption(conn net.Conn, infoHash [20]byte, handshakeMsg []byte, cfg Config) (io.ReadWriter, error) {
+	if cfg.Encryption == EncryptionDisable {
+		if _, err := conn.Write(handshakeMsg); err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}
+
+	allowed := mse.CryptoRC4
+	if cfg.Encryption == EncryptionPrefer {
+		allowed |= mse.CryptoPlaintext
+	}
+
+	stream, err := mse.Negotiate(conn, infoHash, handshakeMsg, allowed)
+	if err != nil {
+		if cfg.Encryption == EncryptionRequire {
+			return nil, fmt.Errorf("client: encryption required but negotiation failed: %w", err)
+		}
+		return nil, err
+	}
+	return stream, nil
+}
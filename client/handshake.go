@@ -0,0 +1,61 @@
+// Description: The BEP 3 handshake: pstrlen, pstr, 8 reserved bytes,
+// infohash, peer ID.
+package client
+
+import (
+	"fmt"
+	"io"
+)
+
+const pstr = "BitTorrent protocol"
+
+// handshake is the fixed-size message both sides of a connection exchange
+// before anything else.
+type handshake struct {
+	Pstr     string
+	InfoHash [20]byte
+	PeerID   [20]byte
+}
+
+func newHandshake(infoHash, peerID [20]byte) *handshake {
+	return &handshake{Pstr: pstr, InfoHash: infoHash, PeerID: peerID}
+}
+
+// Serialize renders h in wire format.
+func (h *handshake) Serialize() []byte {
+	buf := make([]byte, len(h.Pstr)+49)
+	buf[0] = byte(len(h.Pstr))
+	curr := 1
+	curr += copy(buf[curr:], h.Pstr)
+	curr += copy(buf[curr:], make([]byte, 8)) // reserved
+	curr += copy(buf[curr:], h.InfoHash[:])
+	curr += copy(buf[curr:], h.PeerID[:])
+	return buf
+}
+
+// readHandshake reads a handshake from r.
+func readHandshake(r io.Reader) (*handshake, error) {
+	lengthBuf := make([]byte, 1)
+	if _, err := io.ReadFull(r, lengthBuf); err != nil {
+		return nil, err
+	}
+	pstrlen := int(lengthBuf[0])
+	if pstrlen == 0 {
+		return nil, fmt.Errorf("client: pstrlen cannot be 0")
+	}
+
+	handshakeBuf := make([]byte, 48+pstrlen)
+	if _, err := io.ReadFull(r, handshakeBuf); err != nil {
+		return nil, err
+	}
+
+	var infoHash, peerID [20]byte
+	copy(infoHash[:], handshakeBuf[pstrlen+8:pstrlen+8+20])
+	copy(peerID[:], handshakeBuf[pstrlen+8+20:])
+
+	return &handshake{
+		Pstr:     string(handshakeBuf[0:pstrlen]),
+		InfoHash: infoHash,
+		PeerID:   peerID,
+	}, nil
+}
@@ -0,0 +1,27 @@
+// Description: Wraps an already-handshaken inbound connection (accepted by
+// torrent.Server) the same way New wraps an outgoing one, so the rest of
+// the client is agnostic to which side dialed.
+package client
+
+import (
+	"net"
+
+	"bit-torrent/bitfield"
+	"bit-torrent/peers"
+)
+
+// NewFromConn builds a Client around conn after torrent.Server has already
+// completed the BEP 3 handshake in both directions. Peers start choked and
+// with an empty bitfield, same as a freshly dialed Client, until they send
+// their own bitfield or have messages.
+func NewFromConn(conn net.Conn, peerID, infoHash [20]byte, peer peers.Peer) (*Client, error) {
+	return &Client{
+		Conn:      conn,
+		Choked:    true,
+		AmChoking: true,
+		Bitfield:  bitfield.Bitfield{},
+		peer:      peer,
+		infoHash:  infoHash,
+		peerID:    peerID,
+	}, nil
+}
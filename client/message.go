@@ -0,0 +1,147 @@
+// Description: The peer wire protocol's message framing: a 4-byte length
+// prefix, a 1-byte message ID, and an ID-specific payload. A zero-length
+// message (no ID, no payload) is a keep-alive.
+package client
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// messageID identifies the kind of a peer wire message.
+type messageID uint8
+
+const (
+	MsgChoke         messageID = 0
+	MsgUnchoke       messageID = 1
+	MsgInterested    messageID = 2
+	MsgNotInterested messageID = 3
+	MsgHave          messageID = 4
+	MsgBitfield      messageID = 5
+	MsgRequest       messageID = 6
+	MsgPiece         messageID = 7
+	MsgCancel        messageID = 8
+)
+
+// Message is a single peer wire protocol message.
+type Message struct {
+	ID      messageID
+	Payload []byte
+}
+
+// Serialize renders m in wire format, or a zero-length keep-alive if m is
+// nil.
+func (m *Message) Serialize() []byte {
+	if m == nil {
+		return make([]byte, 4)
+	}
+	length := uint32(len(m.Payload) + 1)
+	buf := make([]byte, 4+length)
+	binary.BigEndian.PutUint32(buf[0:4], length)
+	buf[4] = byte(m.ID)
+	copy(buf[5:], m.Payload)
+	return buf
+}
+
+// readMessage reads a single message from r, returning nil for a
+// keep-alive.
+func readMessage(r io.Reader) (*Message, error) {
+	lengthBuf := make([]byte, 4)
+	if _, err := io.ReadFull(r, lengthBuf); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(lengthBuf)
+	if length == 0 {
+		return nil, nil
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+
+	return &Message{ID: messageID(buf[0]), Payload: buf[1:]}, nil
+}
+
+func formatRequest(index, begin, length int) *Message {
+	payload := make([]byte, 12)
+	binary.BigEndian.PutUint32(payload[0:4], uint32(index))
+	binary.BigEndian.PutUint32(payload[4:8], uint32(begin))
+	binary.BigEndian.PutUint32(payload[8:12], uint32(length))
+	return &Message{ID: MsgRequest, Payload: payload}
+}
+
+func formatCancel(index, begin, length int) *Message {
+	payload := make([]byte, 12)
+	binary.BigEndian.PutUint32(payload[0:4], uint32(index))
+	binary.BigEndian.PutUint32(payload[4:8], uint32(begin))
+	binary.BigEndian.PutUint32(payload[8:12], uint32(length))
+	return &Message{ID: MsgCancel, Payload: payload}
+}
+
+func formatHave(index int) *Message {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(index))
+	return &Message{ID: MsgHave, Payload: payload}
+}
+
+func formatPiece(index, begin int, block []byte) *Message {
+	payload := make([]byte, 8+len(block))
+	binary.BigEndian.PutUint32(payload[0:4], uint32(index))
+	binary.BigEndian.PutUint32(payload[4:8], uint32(begin))
+	copy(payload[8:], block)
+	return &Message{ID: MsgPiece, Payload: payload}
+}
+
+// ParsePiece copies a MsgPiece payload's block into buf at the message's
+// begin offset, checking it belongs to pieceIndex and fits within buf. It
+// returns the number of bytes copied, for the caller's downloaded tally.
+func ParsePiece(pieceIndex int, buf []byte, msg *Message) (int, error) {
+	if msg.ID != MsgPiece {
+		return 0, fmt.Errorf("client: expected PIECE (ID %d), got ID %d", MsgPiece, msg.ID)
+	}
+	if len(msg.Payload) < 8 {
+		return 0, fmt.Errorf("client: payload too short, %d < 8", len(msg.Payload))
+	}
+	index := int(binary.BigEndian.Uint32(msg.Payload[0:4]))
+	if index != pieceIndex {
+		return 0, fmt.Errorf("client: expected piece index %d, got %d", pieceIndex, index)
+	}
+	begin := int(binary.BigEndian.Uint32(msg.Payload[4:8]))
+	if begin >= len(buf) {
+		return 0, fmt.Errorf("client: begin offset %d too high, >= %d", begin, len(buf))
+	}
+	block := msg.Payload[8:]
+	if begin+len(block) > len(buf) {
+		return 0, fmt.Errorf("client: block too long (%d) for offset %d, max %d", len(block), begin, len(buf))
+	}
+	copy(buf[begin:], block)
+	return len(block), nil
+}
+
+// ParseRequest decodes a REQUEST (or CANCEL, same layout) message's piece
+// index, block offset, and block length.
+func ParseRequest(msg *Message) (index, begin, length int, err error) {
+	if msg.ID != MsgRequest && msg.ID != MsgCancel {
+		return 0, 0, 0, fmt.Errorf("client: expected REQUEST or CANCEL, got ID %d", msg.ID)
+	}
+	if len(msg.Payload) != 12 {
+		return 0, 0, 0, fmt.Errorf("client: expected REQUEST payload length 12, got %d", len(msg.Payload))
+	}
+	index = int(binary.BigEndian.Uint32(msg.Payload[0:4]))
+	begin = int(binary.BigEndian.Uint32(msg.Payload[4:8]))
+	length = int(binary.BigEndian.Uint32(msg.Payload[8:12]))
+	return index, begin, length, nil
+}
+
+// parseHave returns the piece index a HAVE message announces.
+func parseHave(msg *Message) (int, error) {
+	if msg.ID != MsgHave {
+		return 0, fmt.Errorf("client: expected HAVE (ID %d), got ID %d", MsgHave, msg.ID)
+	}
+	if len(msg.Payload) != 4 {
+		return 0, fmt.Errorf("client: expected HAVE payload length 4, got %d", len(msg.Payload))
+	}
+	return int(binary.BigEndian.Uint32(msg.Payload)), nil
+}
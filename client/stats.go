@@ -0,0 +1,10 @@
+// Description: Cumulative transfer counters consulted by seeder.ChokeManager
+// to rank peers by recent rate without the caller having to report every
+// transferred byte itself.
+package client
+
+// TransferCounts returns the total bytes downloaded from and uploaded to
+// this peer over the life of the connection.
+func (c *Client) TransferCounts() (downloaded, uploaded int64) {
+	return c.downloaded, c.uploaded
+}
@@ -0,0 +1,338 @@
+// Description: A mainline Kademlia DHT (BEP 5) node used to discover peers
+// for torrents that carry no tracker, such as those opened from a magnet
+// link.
+package dht
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// BootstrapNodes are well-known DHT routers used to join the network when
+// the routing table is empty.
+var BootstrapNodes = []string{
+	"router.bittorrent.com:6881",
+	"router.utorrent.com:6881",
+	"dht.transmissionbt.com:6881",
+}
+
+// alpha is the number of closest unqueried nodes kept in flight during an
+// iterative lookup, per the Kademlia paper.
+const alpha = 3
+
+// queryTimeout bounds how long we wait for a single node to answer.
+const queryTimeout = 3 * time.Second
+
+// DHT is a single Kademlia node: a routing table plus a UDP socket used to
+// send and receive KRPC messages.
+type DHT struct {
+	ID    ID
+	Table *RoutingTable
+	conn  *net.UDPConn
+
+	mu      sync.Mutex
+	pending map[string]chan message // transaction ID -> response channel
+
+	tokenMu sync.Mutex
+	tokens  map[string]string // addr -> token that node returned in its get_peers reply
+}
+
+// New creates a DHT node bound to a random UDP port and starts its receive
+// loop. Callers should follow with Bootstrap to join the network.
+func New() (*DHT, error) {
+	id, err := NewID()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: 0})
+	if err != nil {
+		return nil, err
+	}
+	d := &DHT{
+		ID:      id,
+		Table:   NewRoutingTable(id),
+		conn:    conn,
+		pending: make(map[string]chan message),
+		tokens:  make(map[string]string),
+	}
+	go d.serve()
+	return d, nil
+}
+
+// Close shuts down the UDP socket.
+func (d *DHT) Close() error {
+	return d.conn.Close()
+}
+
+// Bootstrap seeds the routing table from the well-known bootstrap routers by
+// asking each of them to find_node the DHT's own ID, inserting both the
+// router itself and every node it returns. Without this, a fresh node's
+// Table stays empty forever and GetPeers has nothing to start its lookup
+// from.
+func (d *DHT) Bootstrap() error {
+	var lastErr error
+	found := false
+	for _, host := range BootstrapNodes {
+		addr, err := net.ResolveUDPAddr("udp", host)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		nodes, routerID, err := d.findNode(addr, d.ID)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		d.Table.Insert(Node{ID: routerID, Addr: addr})
+		for _, n := range nodes {
+			d.Table.Insert(n)
+		}
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("dht: failed to contact any bootstrap node: %w", lastErr)
+	}
+	return nil
+}
+
+// serve reads incoming KRPC packets and either completes a pending query or
+// dispatches to a query handler.
+func (d *DHT) serve() {
+	buf := make([]byte, 4096)
+	for {
+		n, addr, err := d.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		m, err := unmarshalMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		switch m.Y {
+		case "r", "e":
+			d.mu.Lock()
+			ch, ok := d.pending[m.T]
+			d.mu.Unlock()
+			if ok {
+				ch <- m
+			}
+		case "q":
+			go d.handleQuery(m, addr)
+		}
+	}
+}
+
+// roundTrip sends a query and blocks for its reply or queryTimeout.
+func (d *DHT) roundTrip(addr *net.UDPAddr, m message) (message, error) {
+	var tid [4]byte
+	if _, err := rand.Read(tid[:]); err != nil {
+		return message{}, err
+	}
+	m.T = hex.EncodeToString(tid[:])
+
+	ch := make(chan message, 1)
+	d.mu.Lock()
+	d.pending[m.T] = ch
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.pending, m.T)
+		d.mu.Unlock()
+	}()
+
+	raw, err := marshalMessage(m)
+	if err != nil {
+		return message{}, err
+	}
+	if _, err := d.conn.WriteToUDP(raw, addr); err != nil {
+		return message{}, err
+	}
+
+	select {
+	case reply := <-ch:
+		if reply.Y == "e" {
+			return message{}, fmt.Errorf("dht: peer returned error: %v", reply.E)
+		}
+		return reply, nil
+	case <-time.After(queryTimeout):
+		return message{}, fmt.Errorf("dht: query to %s timed out", addr)
+	}
+}
+
+func (d *DHT) ping(addr *net.UDPAddr) (message, error) {
+	return d.roundTrip(addr, message{Y: "q", Q: methodPing, A: &queryArgs{ID: string(d.ID[:])}})
+}
+
+// findNode queries addr for the nodes closest to target, returning both
+// those nodes and addr's own ID (carried in every reply's ID field) so the
+// caller can add addr itself to the routing table.
+func (d *DHT) findNode(addr *net.UDPAddr, target ID) (nodes []Node, responder ID, err error) {
+	reply, err := d.roundTrip(addr, message{
+		Y: "q", Q: methodFindNode,
+		A: &queryArgs{ID: string(d.ID[:]), Target: string(target[:])},
+	})
+	if err != nil {
+		return nil, ID{}, err
+	}
+	if reply.R == nil {
+		return nil, ID{}, fmt.Errorf("dht: find_node reply missing body")
+	}
+	copy(responder[:], reply.R.ID)
+	nodes, err = decodeCompactNodes(reply.R.Nodes)
+	return nodes, responder, err
+}
+
+// getPeers queries a single node for peers of infoHash. It returns any
+// peer addresses in the reply, the closer nodes to continue the lookup
+// with, and the token needed for a later announce_peer.
+func (d *DHT) getPeers(addr *net.UDPAddr, infoHash ID) ([]*net.UDPAddr, []Node, string, error) {
+	reply, err := d.roundTrip(addr, message{
+		Y: "q", Q: methodGetPeers,
+		A: &queryArgs{ID: string(d.ID[:]), InfoHash: string(infoHash[:])},
+	})
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if reply.R == nil {
+		return nil, nil, "", fmt.Errorf("dht: get_peers reply missing body")
+	}
+	peerAddrs := decodeCompactPeers(reply.R.Values)
+	nodes, _ := decodeCompactNodes(reply.R.Nodes)
+	return peerAddrs, nodes, reply.R.Token, nil
+}
+
+// GetPeers performs an iterative Kademlia lookup for peers of infoHash,
+// keeping alpha closest unqueried nodes in flight and stopping once a full
+// round fails to turn up a node closer than the best one already known.
+func (d *DHT) GetPeers(infoHash ID) ([]*net.UDPAddr, error) {
+	target := ID(infoHash)
+	queried := make(map[string]bool)
+	var peersMu sync.Mutex
+	var foundPeers []*net.UDPAddr
+
+	shortlist := d.Table.Closest(target, BucketSize)
+	if len(shortlist) == 0 {
+		if err := d.Bootstrap(); err != nil {
+			return nil, err
+		}
+		shortlist = d.Table.Closest(target, BucketSize)
+	}
+
+	closestSeen := func() Node {
+		sortByDistance(shortlist, target)
+		if len(shortlist) == 0 {
+			return Node{}
+		}
+		return shortlist[0]
+	}
+
+	for {
+		best := closestSeen()
+
+		// Pick up to alpha unqueried nodes from the shortlist.
+		var batch []Node
+		for _, n := range shortlist {
+			key := n.Addr.String()
+			if queried[key] {
+				continue
+			}
+			queried[key] = true
+			batch = append(batch, n)
+			if len(batch) == alpha {
+				break
+			}
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		for _, n := range batch {
+			wg.Add(1)
+			go func(n Node) {
+				defer wg.Done()
+				addrs, nodes, token, err := d.getPeers(n.Addr, target)
+				if err != nil {
+					return
+				}
+				if token != "" {
+					d.tokenMu.Lock()
+					d.tokens[n.Addr.String()] = token
+					d.tokenMu.Unlock()
+				}
+				if len(addrs) > 0 {
+					peersMu.Lock()
+					foundPeers = append(foundPeers, addrs...)
+					peersMu.Unlock()
+				}
+				for _, newNode := range nodes {
+					d.Table.Insert(newNode)
+				}
+				mu.Lock()
+				shortlist = append(shortlist, nodes...)
+				mu.Unlock()
+			}(n)
+		}
+		wg.Wait()
+
+		if closestSeen().ID == best.ID {
+			// A full round didn't improve on the closest node found so far.
+			break
+		}
+	}
+
+	return foundPeers, nil
+}
+
+// handleQuery answers incoming ping/find_node/get_peers/announce_peer
+// queries from other nodes.
+func (d *DHT) handleQuery(m message, addr *net.UDPAddr) {
+	if m.A == nil {
+		return
+	}
+	var from ID
+	copy(from[:], m.A.ID)
+	d.Table.Insert(Node{ID: from, Addr: addr})
+
+	reply := message{T: m.T, Y: "r", R: &replyValues{ID: string(d.ID[:])}}
+
+	switch m.Q {
+	case methodPing:
+		// reply already carries just our ID.
+	case methodFindNode:
+		var target ID
+		copy(target[:], m.A.Target)
+		reply.R.Nodes = encodeCompactNodes(d.Table.Closest(target, BucketSize))
+	case methodGetPeers:
+		var infoHash ID
+		copy(infoHash[:], m.A.InfoHash)
+		reply.R.Nodes = encodeCompactNodes(d.Table.Closest(infoHash, BucketSize))
+		reply.R.Token = announceToken(addr)
+	case methodAnnouncePeer:
+		// Accept the announce; a full implementation would record the
+		// announcing peer for this infohash to serve future get_peers.
+	default:
+		return
+	}
+
+	raw, err := marshalMessage(reply)
+	if err != nil {
+		return
+	}
+	d.conn.WriteToUDP(raw, addr)
+}
+
+// announceToken derives an opaque token for a requesting address, returned
+// to the requester for use in a later announce_peer as required by BEP 5's
+// anti-spoofing measure. The token depends only on the address, so it is
+// the same on every request and does not expire.
+func announceToken(addr *net.UDPAddr) string {
+	h := sha1.Sum([]byte(addr.String()))
+	return string(h[:8])
+}
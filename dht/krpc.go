@@ -0,0 +1,113 @@
+// Description: Bencoded KRPC message types used to talk to other DHT nodes.
+package dht
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+
+	"bit-torrent/bencode"
+)
+
+// KRPC query method names (BEP 5).
+const (
+	methodPing         = "ping"
+	methodFindNode     = "find_node"
+	methodGetPeers     = "get_peers"
+	methodAnnouncePeer = "announce_peer"
+)
+
+// message is the envelope every KRPC packet shares: a transaction ID, a
+// type ("q" query, "r" response, "e" error), and type-specific payloads.
+type message struct {
+	T string          `bencode:"t"`
+	Y string          `bencode:"y"`
+	Q string          `bencode:"q,omitempty"`
+	A *queryArgs      `bencode:"a,omitempty"`
+	R *replyValues    `bencode:"r,omitempty"`
+	E []interface{}   `bencode:"e,omitempty"`
+}
+
+// queryArgs covers the arguments of every query type; unused fields are
+// simply omitted on the wire.
+type queryArgs struct {
+	ID         string `bencode:"id"`
+	Target     string `bencode:"target,omitempty"`
+	InfoHash   string `bencode:"info_hash,omitempty"`
+	Port       int    `bencode:"port,omitempty"`
+	ImpliedPort int   `bencode:"implied_port,omitempty"`
+	Token      string `bencode:"token,omitempty"`
+}
+
+// replyValues covers the fields of every reply type.
+type replyValues struct {
+	ID     string   `bencode:"id"`
+	Nodes  string   `bencode:"nodes,omitempty"`
+	Token  string   `bencode:"token,omitempty"`
+	Values []string `bencode:"values,omitempty"`
+}
+
+// encodeCompactNodes packs a slice of nodes into BEP 5's compact node_info
+// format: 20-byte ID + 4-byte IPv4 + 2-byte port, concatenated.
+func encodeCompactNodes(nodes []Node) string {
+	buf := make([]byte, 0, len(nodes)*26)
+	for _, n := range nodes {
+		ip4 := n.Addr.IP.To4()
+		if ip4 == nil {
+			continue
+		}
+		buf = append(buf, n.ID[:]...)
+		buf = append(buf, ip4...)
+		buf = append(buf, byte(n.Addr.Port>>8), byte(n.Addr.Port))
+	}
+	return string(buf)
+}
+
+// decodeCompactNodes is the inverse of encodeCompactNodes.
+func decodeCompactNodes(s string) ([]Node, error) {
+	const entryLen = 26
+	b := []byte(s)
+	if len(b)%entryLen != 0 {
+		return nil, fmt.Errorf("dht: malformed compact nodes of length %d", len(b))
+	}
+	nodes := make([]Node, 0, len(b)/entryLen)
+	for i := 0; i+entryLen <= len(b); i += entryLen {
+		var id ID
+		copy(id[:], b[i:i+idLen])
+		ip := net.IPv4(b[i+20], b[i+21], b[i+22], b[i+23])
+		port := int(b[i+24])<<8 | int(b[i+25])
+		nodes = append(nodes, Node{ID: id, Addr: &net.UDPAddr{IP: ip, Port: port}})
+	}
+	return nodes, nil
+}
+
+// decodeCompactPeers parses BEP 5's "values" list: each entry is a 6-byte
+// compact IPv4 peer (4-byte IP + 2-byte port), the same format the tracker
+// uses.
+func decodeCompactPeers(values []string) []*net.UDPAddr {
+	addrs := make([]*net.UDPAddr, 0, len(values))
+	for _, v := range values {
+		b := []byte(v)
+		if len(b) != 6 {
+			continue
+		}
+		ip := net.IPv4(b[0], b[1], b[2], b[3])
+		port := int(b[4])<<8 | int(b[5])
+		addrs = append(addrs, &net.UDPAddr{IP: ip, Port: port})
+	}
+	return addrs
+}
+
+func marshalMessage(m message) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := bencode.Marshal(&buf, m); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func unmarshalMessage(b []byte) (message, error) {
+	var m message
+	err := bencode.Unmarshal(bytes.NewReader(b), &m)
+	return m, err
+}
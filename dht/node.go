@@ -0,0 +1,147 @@
+// Description: Node identifiers and the Kademlia routing table used by the DHT.
+package dht
+
+import (
+	"crypto/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// idLen is the length in bytes of a DHT node ID / infohash (BEP 5 uses the
+// same 160-bit space as the BitTorrent infohash).
+const idLen = 20
+
+// NumBuckets is the number of k-buckets in the routing table, one per bit of
+// the 160-bit ID space.
+const NumBuckets = idLen * 8
+
+// BucketSize (k) is the maximum number of nodes held in a single k-bucket.
+const BucketSize = 8
+
+// ID is a 160-bit Kademlia node ID.
+type ID [idLen]byte
+
+// NewID generates a random node ID.
+func NewID() (ID, error) {
+	var id ID
+	_, err := rand.Read(id[:])
+	return id, err
+}
+
+// distance returns the XOR metric distance between two IDs.
+func distance(a, b ID) ID {
+	var d ID
+	for i := range d {
+		d[i] = a[i] ^ b[i]
+	}
+	return d
+}
+
+// bucketIndex returns which k-bucket a node with the given distance from the
+// routing table's own ID falls into, i.e. the index of the highest set bit.
+func bucketIndex(d ID) int {
+	for i, b := range d {
+		if b == 0 {
+			continue
+		}
+		for bit := 0; bit < 8; bit++ {
+			if b&(0x80>>uint(bit)) != 0 {
+				return i*8 + bit
+			}
+		}
+	}
+	return NumBuckets - 1
+}
+
+// Node is a single entry in the routing table: an ID paired with the
+// UDP address it was last seen at.
+type Node struct {
+	ID       ID
+	Addr     *net.UDPAddr
+	LastSeen time.Time
+}
+
+// bucket holds up to BucketSize nodes, ordered least-recently-seen first, as
+// required by BEP 5's "prefer long-lived nodes" eviction policy.
+type bucket struct {
+	nodes []Node
+}
+
+func (b *bucket) insert(n Node) {
+	for i, existing := range b.nodes {
+		if existing.ID == n.ID {
+			b.nodes = append(b.nodes[:i], b.nodes[i+1:]...)
+			b.nodes = append(b.nodes, n)
+			return
+		}
+	}
+	if len(b.nodes) < BucketSize {
+		b.nodes = append(b.nodes, n)
+		return
+	}
+	// Bucket is full: the new node is dropped and the existing entries are
+	// kept. Nothing pings or evicts the least-recently-seen node, so a full
+	// bucket does not change until one of its own nodes is seen again.
+}
+
+// RoutingTable is a Kademlia routing table of NumBuckets k-buckets keyed by
+// XOR distance from Self.
+type RoutingTable struct {
+	mu      sync.Mutex
+	Self    ID
+	buckets [NumBuckets]bucket
+}
+
+// NewRoutingTable creates an empty routing table rooted at self.
+func NewRoutingTable(self ID) *RoutingTable {
+	return &RoutingTable{Self: self}
+}
+
+// Insert records that a node was seen, updating or adding it to the
+// appropriate k-bucket.
+func (rt *RoutingTable) Insert(n Node) {
+	if n.ID == rt.Self {
+		return
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	idx := bucketIndex(distance(rt.Self, n.ID))
+	n.LastSeen = time.Now()
+	rt.buckets[idx].insert(n)
+}
+
+// Closest returns the up-to-count nodes in the table closest to target.
+func (rt *RoutingTable) Closest(target ID, count int) []Node {
+	rt.mu.Lock()
+	all := make([]Node, 0, count*2)
+	for i := range rt.buckets {
+		all = append(all, rt.buckets[i].nodes...)
+	}
+	rt.mu.Unlock()
+
+	sortByDistance(all, target)
+	if len(all) > count {
+		all = all[:count]
+	}
+	return all
+}
+
+func sortByDistance(nodes []Node, target ID) {
+	less := func(i, j int) bool {
+		di := distance(nodes[i].ID, target)
+		dj := distance(nodes[j].ID, target)
+		for k := range di {
+			if di[k] != dj[k] {
+				return di[k] < dj[k]
+			}
+		}
+		return false
+	}
+	// Simple insertion sort: bucket counts are tiny (<= 8 * NumBuckets).
+	for i := 1; i < len(nodes); i++ {
+		for j := i; j > 0 && less(j, j-1); j-- {
+			nodes[j], nodes[j-1] = nodes[j-1], nodes[j]
+		}
+	}
+}
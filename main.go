@@ -7,12 +7,13 @@ import (
 	"os"
 	"sync"
 
+	"bit-torrent/client"
 	"bit-torrent/seeder"
 	"bit-torrent/torrent"
 )
 
 // main is the entry point for the program
-// It takes in two arguments: the path to the .torrent file and the path to the file to be downloaded to (the file must not exist)
+// It takes in two arguments: the path to the .torrent file and the destination directory to download into
 // It connects to peers and downloads the file
 // It then starts seeding the file to the peers that are connected to it and waits for the user to press enter to exit
 func main() {
@@ -30,6 +31,21 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Listen for inbound connections from other peers so we're not only
+	// ever the one dialing out, and register this torrent so incoming
+	// handshakes for its infohash are accepted.
+	server, err := torrent.NewServer(torrent.Port, tor.PeerID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer server.Close()
+	incoming := server.Register(tor.InfoHash)
+	go func() {
+		if err := server.Serve(); err != nil {
+			log.Printf("inbound listener stopped: %v\n", err)
+		}
+	}()
+
 	// Connect to peers and download file and start seeding
 	keepAliveChan := make(chan bool)
 	clients, err := torrent.ConnectToPeers(tor, keepAliveChan)
@@ -37,33 +53,93 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	// clientsMu guards clients: both the keepalive loop and the incoming-peer
+	// loop below read or append to it from their own goroutines.
+	var clientsMu sync.Mutex
+
 	go func() {
 		for {
 			select {
 			case <-keepAliveChan:
-				for _, c := range clients {
+				clientsMu.Lock()
+				snapshot := append([]*client.Client(nil), clients...)
+				clientsMu.Unlock()
+				for _, c := range snapshot {
 					c.SendKeepAlive()
 				}
 			}
 		}
 	}()
 
+	choker := seeder.NewChokeManager(false)
+	for _, c := range clients {
+		choker.AddPeer(c)
+	}
+	choker.Run()
+	go func() {
+		for c := range incoming {
+			clientsMu.Lock()
+			clients = append(clients, c)
+			clientsMu.Unlock()
+			choker.AddPeer(c)
+		}
+	}()
+
+	// Ask our initial peers for any further peers they know about via PEX,
+	// since the tracker/DHT sources only ran once before any connection
+	// existed. Best-effort: a torrent with no PEX-capable peers just won't
+	// gain any.
+	go func() {
+		clientsMu.Lock()
+		seed := append([]*client.Client(nil), clients...)
+		clientsMu.Unlock()
+		found, err := torrent.DiscoverPEXPeers(seed, tor.PeerID, tor.InfoHash)
+		if err != nil {
+			return
+		}
+		for _, p := range found {
+			c, err := client.New(p, tor.PeerID, tor.InfoHash, client.Config{})
+			if err != nil {
+				continue
+			}
+			log.Printf("pex: connected to new peer %s\n", p)
+			clientsMu.Lock()
+			clients = append(clients, c)
+			clientsMu.Unlock()
+			choker.AddPeer(c)
+		}
+	}()
+
 	// Download file and start seeding
-	err = tf.DownloadToFile(outPath, tor, clients)
+	clientsMu.Lock()
+	downloadClients := append([]*client.Client(nil), clients...)
+	clientsMu.Unlock()
+	err = tf.DownloadToFile(outPath, tor, downloadClients)
 	if err != nil {
 		log.Fatal(err)
 	}
-	//
+	if err := tf.AnnounceCompleted(tor.PeerID, server.Port()); err != nil {
+		log.Printf("failed to announce completion: %v\n", err)
+	}
+
 	var wg sync.WaitGroup
 	// Add one to the wait group
 	wg.Add(1)
 	// Start seeding the file
 	go func() {
 		defer wg.Done()
-		seeder.SeedFile(clients, tor, outPath)
+		clientsMu.Lock()
+		seedClients := append([]*client.Client(nil), clients...)
+		clientsMu.Unlock()
+		seeder.SeedFile(seedClients, tor, outPath)
 	}()
 	// Wait for user to press enter to exit
 	fmt.Println("Leeching and seeding complete. Press enter to exit")
 	wg.Wait()
+
+	choker.Stop()
+	if err := tf.AnnounceStopped(tor.PeerID, server.Port()); err != nil {
+		log.Printf("failed to announce stop: %v\n", err)
+	}
 	fmt.Println("Exiting...")
 }
\ No newline at end of file
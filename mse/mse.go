@@ -0,0 +1,63 @@
+// Package mse implements Message Stream Encryption (MSE), the de-facto
+// obfuscation scheme used by mainstream BitTorrent clients to keep the
+// wire handshake and subsequent traffic from being trivially fingerprinted
+// and throttled by ISPs. It is not a real cryptographic protection against
+// an attacker who can already see the infohash.
+package mse
+
+import (
+	"crypto/rand"
+	"math/big"
+)
+
+// p is the 768-bit MODP prime (the spec's "Ia" value) and g its generator,
+// used for the Diffie-Hellman exchange that derives the RC4 stream keys.
+var (
+	p, _ = new(big.Int).SetString(
+		"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD"+
+			"129024E088A67CC74020BBEA63B139B22514A08798E3404"+
+			"DDEF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C"+
+			"245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406"+
+			"B7EDEE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3DC2007CB8A163BF0598DA48361C55D39A69163FA8FD24CF5F83655D23DCA3AD961C62F356208552BB9ED529077096966D670C354E4ABC9804F1746C08CA237327FFFFFFFFFFFFFFFF",
+		16)
+	g = big.NewInt(2)
+)
+
+// privateKeyBits is the size of each side's DH private exponent, per spec.
+const privateKeyBits = 160
+
+// KeyPair is one side's Diffie-Hellman key material.
+type KeyPair struct {
+	Private *big.Int
+	Public  *big.Int // g^Private mod p
+}
+
+// GenerateKeyPair generates a random 160-bit private key and its public
+// counterpart g^x mod p.
+func GenerateKeyPair() (KeyPair, error) {
+	priv, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), privateKeyBits))
+	if err != nil {
+		return KeyPair{}, err
+	}
+	pub := new(big.Int).Exp(g, priv, p)
+	return KeyPair{Private: priv, Public: pub}, nil
+}
+
+// SharedSecret computes S = (peerPublic ^ ourPrivate) mod p.
+func SharedSecret(kp KeyPair, peerPublic *big.Int) *big.Int {
+	return new(big.Int).Exp(peerPublic, kp.Private, p)
+}
+
+// PublicKeyBytes renders a public key as a fixed-width big-endian byte
+// string the size of p, as required on the wire.
+func PublicKeyBytes(pub *big.Int) []byte {
+	out := make([]byte, (p.BitLen()+7)/8)
+	b := pub.Bytes()
+	copy(out[len(out)-len(b):], b)
+	return out
+}
+
+// BytesToPublicKey is the inverse of PublicKeyBytes.
+func BytesToPublicKey(b []byte) *big.Int {
+	return new(big.Int).SetBytes(b)
+}
@@ -0,0 +1,60 @@
+// Description: RC4 stream key derivation per the MSE spec: each direction
+// gets its own key hashed from the shared secret, a fixed label, and the
+// torrent's infohash (SKEY), so two torrents sharing a peer connection
+// never reuse a stream key.
+package mse
+
+import (
+	"crypto/rc4"
+	"crypto/sha1"
+)
+
+// streamKey derives the RC4 key for one direction of the connection.
+// label is "keyA" for the side that initiated (outgoing) and "keyB" for
+// the side that received (incoming), per spec.
+func streamKey(label string, secret []byte, infoHash [20]byte) [20]byte {
+	h := sha1.New()
+	h.Write([]byte(label))
+	h.Write(secret)
+	h.Write(infoHash[:])
+	var out [20]byte
+	copy(out[:], h.Sum(nil))
+	return out
+}
+
+// NewCiphers returns the outgoing and incoming RC4 ciphers for a
+// connection we initiated: we encrypt with keyA and decrypt with keyB.
+// The first 1024 bytes of keystream are discarded for both, per spec,
+// since RC4's early output is not uniformly random.
+func NewCiphers(secret []byte, infoHash [20]byte) (outgoing, incoming *rc4.Cipher, err error) {
+	keyA := streamKey("keyA", secret, infoHash)
+	keyB := streamKey("keyB", secret, infoHash)
+
+	outgoing, err = rc4.NewCipher(keyA[:])
+	if err != nil {
+		return nil, nil, err
+	}
+	incoming, err = rc4.NewCipher(keyB[:])
+	if err != nil {
+		return nil, nil, err
+	}
+	discard(outgoing)
+	discard(incoming)
+	return outgoing, incoming, nil
+}
+
+func discard(c *rc4.Cipher) {
+	junk := make([]byte, 1024)
+	c.XORKeyStream(junk, junk)
+}
+
+// sha1SumConcat hashes the concatenation of label with any non-empty extra
+// byte slices, e.g. HASH('req1', S) or HASH('req2', SKEY).
+func sha1SumConcat(label []byte, parts ...[]byte) []byte {
+	h := sha1.New()
+	h.Write(label)
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
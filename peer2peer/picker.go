@@ -0,0 +1,166 @@
+// Description: A piece picker for Torrent.Download that replaces sequential
+// assignment with rarest-first selection, user-set piece priorities, and an
+// endgame mode that broadcasts the final few requests to every unchoked
+// peer and cancels whichever copies lose the race.
+package peer2peer
+
+import (
+	"sync"
+)
+
+// Priority controls how eagerly the picker schedules a piece relative to
+// others; it is compared before rarity, so future callers (e.g. streaming
+// playback) can pull the first/last piece of a file ahead of the rest.
+type Priority int
+
+const (
+	PriorityDoNotDownload Priority = iota
+	PriorityNormal
+	PriorityHigh
+	PriorityNow
+)
+
+// endgameThreshold is how many outstanding pieces remain before the picker
+// switches to endgame mode and starts duplicating requests.
+const endgameThreshold = 8
+
+// pieceState tracks in-flight requests for a single piece so we know who to
+// send `cancel` to once a block arrives from someone else.
+type pieceState struct {
+	have      bool
+	priority  Priority
+	rarity    int         // number of peers known to have this piece
+	requestedBy []int // client indices that currently have an outstanding request for this piece
+}
+
+// Picker tracks piece availability across the swarm and decides which piece
+// to request next, in rarest-first order, switching to endgame mode as the
+// download nears completion.
+type Picker struct {
+	mu     sync.Mutex
+	pieces []pieceState
+}
+
+// NewPicker creates a picker for a torrent with numPieces pieces, all at
+// normal priority.
+func NewPicker(numPieces int) *Picker {
+	p := &Picker{pieces: make([]pieceState, numPieces)}
+	for i := range p.pieces {
+		p.pieces[i].priority = PriorityNormal
+	}
+	return p
+}
+
+// SetPriority changes the scheduling priority of a single piece, e.g. to
+// push the first and last piece of a file to the front for streaming.
+func (p *Picker) SetPriority(index int, priority Priority) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pieces[index].priority = priority
+}
+
+// MarkHave records that some peer advertised ownership of a piece, via
+// either a Bitfield or a Have message, bumping that piece's rarity count.
+func (p *Picker) MarkHave(pieceIndex int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pieceIndex < 0 || pieceIndex >= len(p.pieces) {
+		return
+	}
+	p.pieces[pieceIndex].rarity++
+}
+
+// MarkComplete records that a piece has been downloaded and verified, so it
+// is no longer considered for picking.
+func (p *Picker) MarkComplete(index int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pieces[index].have = true
+	p.pieces[index].requestedBy = nil
+}
+
+// outstanding returns the number of pieces that are neither complete nor
+// excluded via PriorityDoNotDownload.
+func (p *Picker) outstanding() int {
+	n := 0
+	for _, ps := range p.pieces {
+		if !ps.have && ps.priority != PriorityDoNotDownload {
+			n++
+		}
+	}
+	return n
+}
+
+// InEndgame reports whether at most endgameThreshold (and at least one)
+// pieces remain, at
+// which point every unchoked peer should be asked for every outstanding
+// piece instead of waiting on single assignments.
+func (p *Picker) InEndgame() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.outstanding() > 0 && p.outstanding() <= endgameThreshold
+}
+
+// NextPiece chooses the next piece to request from clientIdx: the
+// highest-priority, rarest piece that isn't already complete and (outside
+// endgame) isn't already assigned to another peer.
+func (p *Picker) NextPiece(clientIdx int, hasPiece func(index int) bool) (index int, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	endgame := p.outstanding() > 0 && p.outstanding() <= endgameThreshold
+	best := -1
+	for i, ps := range p.pieces {
+		if ps.have || ps.priority == PriorityDoNotDownload || !hasPiece(i) {
+			continue
+		}
+		if !endgame && len(ps.requestedBy) > 0 {
+			continue
+		}
+		if endgame && containsInt(ps.requestedBy, clientIdx) {
+			continue
+		}
+		if best == -1 || better(ps, p.pieces[best]) {
+			best = i
+		}
+	}
+	if best == -1 {
+		return 0, false
+	}
+	p.pieces[best].requestedBy = append(p.pieces[best].requestedBy, clientIdx)
+	return best, true
+}
+
+// better reports whether a should be scheduled before b: higher priority
+// wins outright, then rarer (lower rarity) wins.
+func better(a, b pieceState) bool {
+	if a.priority != b.priority {
+		return a.priority > b.priority
+	}
+	return a.rarity < b.rarity
+}
+
+func containsInt(xs []int, v int) bool {
+	for _, x := range xs {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// CancelTargets returns the client indices (other than fromClient) that
+// have an outstanding request for index, so the caller can send them
+// `cancel` once fromClient's block has arrived — only relevant in endgame
+// mode, where a piece may have been requested from several peers at once.
+func (p *Picker) CancelTargets(index, fromClient int) []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var targets []int
+	for _, c := range p.pieces[index].requestedBy {
+		if c != fromClient {
+			targets = append(targets, c)
+		}
+	}
+	return targets
+}
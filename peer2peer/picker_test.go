@@ -0,0 +1,111 @@
+package peer2peer
+
+import "testing"
+
+func hasAll(index int) bool { return true }
+
+func TestNextPieceRarestFirst(t *testing.T) {
+	p := NewPicker(3)
+	// Piece 0 is common, piece 1 is rarer, piece 2 is rarest.
+	p.MarkHave(0)
+	p.MarkHave(0)
+	p.MarkHave(0)
+	p.MarkHave(1)
+	p.MarkHave(1)
+	p.MarkHave(2)
+
+	index, ok := p.NextPiece(0, hasAll)
+	if !ok {
+		t.Fatal("expected a piece to be picked")
+	}
+	if index != 2 {
+		t.Fatalf("expected rarest piece (2) to be picked first, got %d", index)
+	}
+}
+
+func TestNextPiecePriorityBeatsRarity(t *testing.T) {
+	p := NewPicker(2)
+	p.MarkHave(0) // rarity 1
+	// piece 1 stays at rarity 0 (rarer) but low priority
+	p.SetPriority(1, PriorityNormal)
+	p.SetPriority(0, PriorityNow)
+
+	index, ok := p.NextPiece(0, hasAll)
+	if !ok || index != 0 {
+		t.Fatalf("expected high-priority piece 0 to win despite lower rarity, got index=%d ok=%v", index, ok)
+	}
+}
+
+func TestNextPieceExcludesAlreadyComplete(t *testing.T) {
+	p := NewPicker(2)
+	p.MarkComplete(0)
+
+	index, ok := p.NextPiece(0, hasAll)
+	if !ok || index != 1 {
+		t.Fatalf("expected only incomplete piece 1 to be picked, got index=%d ok=%v", index, ok)
+	}
+}
+
+func TestNextPieceRequiresPeerHasPiece(t *testing.T) {
+	p := NewPicker(2)
+	hasOnly1 := func(index int) bool { return index == 1 }
+
+	index, ok := p.NextPiece(0, hasOnly1)
+	if !ok || index != 1 {
+		t.Fatalf("expected piece 1 (the only one this peer has), got index=%d ok=%v", index, ok)
+	}
+}
+
+func TestNextPieceOutsideEndgameSkipsAlreadyRequested(t *testing.T) {
+	// outstanding must stay above endgameThreshold to stay out of endgame.
+	p := NewPicker(endgameThreshold + 2)
+	if _, ok := p.NextPiece(0, hasAll); !ok {
+		t.Fatal("expected first request to succeed")
+	}
+	if index, ok := p.NextPiece(1, hasAll); !ok || index == 0 {
+		t.Fatalf("expected second peer to skip the already-claimed piece 0 outside endgame, got index=%d ok=%v", index, ok)
+	}
+}
+
+func TestNextPieceEndgameDuplicatesAcrossPeers(t *testing.T) {
+	p := NewPicker(endgameThreshold)
+	// Only piece 0 is eligible, so outstanding() is 1 and endgame is
+	// active regardless of the picker's overall size.
+	for i := 1; i < endgameThreshold; i++ {
+		p.SetPriority(i, PriorityDoNotDownload)
+	}
+
+	if index, ok := p.NextPiece(0, hasAll); !ok || index != 0 {
+		t.Fatalf("expected first request to succeed on piece 0, got index=%d ok=%v", index, ok)
+	}
+	if index, ok := p.NextPiece(1, hasAll); !ok || index != 0 {
+		t.Fatalf("expected endgame to duplicate the same piece to peer 1, got index=%d ok=%v", index, ok)
+	}
+	// The same peer shouldn't be handed a second outstanding request for a
+	// piece it's already requested.
+	if _, ok := p.NextPiece(0, hasAll); ok {
+		t.Fatal("expected peer 0 not to be re-assigned a piece it already requested")
+	}
+}
+
+func TestCancelTargetsExcludesRequester(t *testing.T) {
+	p := NewPicker(endgameThreshold)
+	p.NextPiece(0, hasAll)
+	p.NextPiece(1, hasAll)
+
+	targets := p.CancelTargets(0, 0)
+	if len(targets) != 1 || targets[0] != 1 {
+		t.Fatalf("expected cancel target [1], got %v", targets)
+	}
+}
+
+func TestInEndgame(t *testing.T) {
+	p := NewPicker(endgameThreshold + 1)
+	if p.InEndgame() {
+		t.Fatal("expected not to be in endgame with outstanding pieces above the threshold")
+	}
+	p.MarkComplete(0)
+	if !p.InEndgame() {
+		t.Fatal("expected to be in endgame once outstanding pieces drop to the threshold")
+	}
+}
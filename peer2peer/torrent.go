@@ -0,0 +1,213 @@
+// Description: Torrent ties a parsed torrent's metadata to a swarm of
+// already-handshaken clients and drives the actual piece exchange, using
+// Picker for rarest-first scheduling and endgame cancellation.
+package peer2peer
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"log"
+	"time"
+
+	"bit-torrent/client"
+	"bit-torrent/peers"
+	"bit-torrent/storage"
+)
+
+// MaxBlockSize is the largest block we ever request in a single message;
+// peers are free to reject larger requests, and most cap at this size.
+const MaxBlockSize = 16384
+
+// MaxBacklog is how many block requests we keep in flight at once to a
+// single peer, to keep its pipe full without over-committing.
+const MaxBacklog = 5
+
+// Torrent holds everything needed to download a torrent's content once its
+// metadata (from a .torrent file or BEP 9) and swarm are known.
+type Torrent struct {
+	Peers       []peers.Peer
+	PeerID      [20]byte
+	InfoHash    [20]byte
+	PieceHashes [][20]byte
+	PieceLength int
+	Length      int
+	Name        string
+	// Files is the per-file layout in the form storage.FileStorage
+	// expects; for a single-file torrent it holds one entry named Name
+	// with length Length.
+	Files []storage.FileSpec
+}
+
+type pieceResult struct {
+	index int
+	buf   []byte
+}
+
+// pieceLen returns the length of piece index, accounting for the final
+// piece being shorter than PieceLength.
+func (t *Torrent) pieceLen(index int) int {
+	begin := index * t.PieceLength
+	end := begin + t.PieceLength
+	if end > t.Length {
+		end = t.Length
+	}
+	return end - begin
+}
+
+// Download fetches every piece of the torrent from clients, using Picker to
+// hand out rarest-first assignments and switch to endgame broadcast once
+// few pieces remain, and writes each piece to store as soon as it arrives
+// and passes its integrity check — the torrent is never held in memory as
+// a single buffer, so this works the same whether the torrent is a few
+// kilobytes or hundreds of gigabytes. Pieces store already reports as
+// complete (e.g. verified on a resumed download) are skipped.
+func (t *Torrent) Download(clients []*client.Client, store storage.PieceStorage) error {
+	if len(clients) == 0 {
+		return fmt.Errorf("peer2peer: no clients to download from")
+	}
+
+	picker := NewPicker(len(t.PieceHashes))
+	for _, c := range clients {
+		for i := range t.PieceHashes {
+			if c.Bitfield.HasPiece(i) {
+				picker.MarkHave(i)
+			}
+		}
+	}
+
+	donePieces := 0
+	for i, done := range store.Completion() {
+		if done {
+			picker.MarkComplete(i)
+			donePieces++
+		}
+	}
+	if donePieces == len(t.PieceHashes) {
+		return nil
+	}
+
+	results := make(chan pieceResult)
+	done := make(chan int, len(clients))
+	for idx, c := range clients {
+		go t.runWorker(idx, c, clients, picker, results, done)
+	}
+
+	activeWorkers := len(clients)
+	for donePieces < len(t.PieceHashes) && activeWorkers > 0 {
+		select {
+		case res := <-results:
+			if _, err := store.WriteAt(res.index, res.buf, 0); err != nil {
+				return err
+			}
+			if err := store.MarkComplete(res.index); err != nil {
+				return err
+			}
+			picker.MarkComplete(res.index)
+			donePieces++
+			log.Printf("peer2peer: (%d/%d) downloaded piece #%d\n", donePieces, len(t.PieceHashes), res.index)
+		case <-done:
+			activeWorkers--
+		}
+	}
+
+	if donePieces < len(t.PieceHashes) {
+		return fmt.Errorf("peer2peer: all peers disconnected with %d/%d pieces remaining", len(t.PieceHashes)-donePieces, len(t.PieceHashes))
+	}
+
+	return nil
+}
+
+// runWorker repeatedly asks picker for the next piece clientIdx can help
+// with, downloads it, and publishes the result, until the peer disconnects
+// or the picker has nothing left this peer can serve.
+func (t *Torrent) runWorker(clientIdx int, c *client.Client, clients []*client.Client, picker *Picker, results chan<- pieceResult, done chan<- int) {
+	defer func() { done <- clientIdx }()
+
+	c.SendUnchoke()
+	c.SendInterested()
+
+	idle := 0
+	for {
+		index, ok := picker.NextPiece(clientIdx, c.Bitfield.HasPiece)
+		if !ok {
+			if picker.outstanding() == 0 {
+				return
+			}
+			// Nothing assigned to us right now (e.g. choked, or every
+			// outstanding piece is already claimed outside endgame);
+			// the swarm may still hand us work once it progresses.
+			idle++
+			if idle > 200 {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+		idle = 0
+
+		buf, err := t.downloadPiece(c, index)
+		if err != nil {
+			log.Printf("peer2peer: %s failed piece #%d: %v\n", c, index, err)
+			return
+		}
+		if !checkIntegrity(t.PieceHashes[index], buf) {
+			log.Printf("peer2peer: %s sent piece #%d that failed integrity check\n", c, index)
+			continue
+		}
+
+		for _, target := range picker.CancelTargets(index, clientIdx) {
+			clients[target].SendCancel(index, 0, len(buf))
+		}
+
+		results <- pieceResult{index: index, buf: buf}
+	}
+}
+
+// downloadPiece requests and assembles a single piece from c, keeping up to
+// MaxBacklog requests in flight.
+func (t *Torrent) downloadPiece(c *client.Client, index int) ([]byte, error) {
+	length := t.pieceLen(index)
+	buf := make([]byte, length)
+
+	var downloaded, requested, backlog int
+	for downloaded < length {
+		if !c.Choked {
+			for backlog < MaxBacklog && requested < length {
+				blockSize := MaxBlockSize
+				if length-requested < blockSize {
+					blockSize = length - requested
+				}
+				if err := c.SendRequest(index, requested, blockSize); err != nil {
+					return nil, err
+				}
+				backlog++
+				requested += blockSize
+			}
+		}
+
+		msg, err := c.Read()
+		if err != nil {
+			return nil, err
+		}
+		if msg == nil {
+			continue // keep-alive
+		}
+		if msg.ID == client.MsgPiece {
+			n, err := client.ParsePiece(index, buf, msg)
+			if err != nil {
+				return nil, err
+			}
+			downloaded += n
+			backlog--
+		}
+	}
+
+	return buf, nil
+}
+
+// checkIntegrity verifies buf matches its expected SHA-1 hash.
+func checkIntegrity(hash [20]byte, buf []byte) bool {
+	sum := sha1.Sum(buf)
+	return bytes.Equal(sum[:], hash[:])
+}
@@ -0,0 +1,39 @@
+// Package peers decodes the compact peer list format trackers and the DHT
+// both use: one 6-byte entry per peer (4-byte IPv4 address, 2-byte port).
+package peers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// Peer is a single peer's address, as advertised by a tracker, the DHT, or
+// an inbound connection.
+type Peer struct {
+	IP   net.IP
+	Port uint16
+}
+
+// String renders a peer as "host:port".
+func (p Peer) String() string {
+	return net.JoinHostPort(p.IP.String(), fmt.Sprintf("%d", p.Port))
+}
+
+// peerSize is the length in bytes of one compact peer entry.
+const peerSize = 6
+
+// Unmarshal parses a compact peer list into a slice of Peer.
+func Unmarshal(peersBin []byte) ([]Peer, error) {
+	if len(peersBin)%peerSize != 0 {
+		return nil, fmt.Errorf("peers: received malformed peers of length %d", len(peersBin))
+	}
+	numPeers := len(peersBin) / peerSize
+	out := make([]Peer, numPeers)
+	for i := 0; i < numPeers; i++ {
+		offset := i * peerSize
+		out[i].IP = net.IP(peersBin[offset : offset+4])
+		out[i].Port = binary.BigEndian.Uint16(peersBin[offset+4 : offset+6])
+	}
+	return out, nil
+}
@@ -0,0 +1,176 @@
+// Description: The standard choking algorithm (tit-for-tat while leeching,
+// top-uploaders-first while seeding) plus the rotating optimistic unchoke,
+// run as a background loop alongside SeedFile.
+package seeder
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"bit-torrent/client"
+)
+
+// rechokeInterval is how often the top unchoked peers are recomputed.
+const rechokeInterval = 10 * time.Second
+
+// optimisticInterval is how often the optimistic unchoke is rotated to a
+// new random choked peer, giving newcomers a chance to prove themselves.
+const optimisticInterval = 30 * time.Second
+
+// maxUnchoked is the number of peers kept unchoked by rate alone, not
+// counting the optimistic unchoke.
+const maxUnchoked = 4
+
+// lastCounts remembers the cumulative transfer counts a peer reported as of
+// the previous rechoke, so the delta since then can stand in for its
+// current rate.
+type lastCounts struct {
+	downloaded, uploaded int64
+}
+
+// ChokeManager implements the choking algorithm across a set of peers:
+// every rechokeInterval it unchokes the top maxUnchoked peers by recent
+// rate (download rate while leeching, upload rate while seeding) plus one
+// optimistic unchoke rotated every optimisticInterval. It polls each
+// peer's cumulative client.Client.TransferCounts rather than requiring
+// every transfer to be reported in, so there's no separate bookkeeping
+// call for the download/upload loops to remember to make.
+type ChokeManager struct {
+	mu      sync.Mutex
+	peers   map[*client.Client]*lastCounts
+	seeding bool
+
+	stop chan struct{}
+}
+
+// NewChokeManager creates a choke manager. seeding selects which side of
+// the rate is used to rank peers: upload rate once we have the whole
+// torrent and are only seeding, download rate while still leeching.
+func NewChokeManager(seeding bool) *ChokeManager {
+	return &ChokeManager{
+		peers:   make(map[*client.Client]*lastCounts),
+		seeding: seeding,
+		stop:    make(chan struct{}),
+	}
+}
+
+// AddPeer starts tracking a newly connected peer, choked until the next
+// rechoke.
+func (m *ChokeManager) AddPeer(c *client.Client) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	down, up := c.TransferCounts()
+	m.peers[c] = &lastCounts{downloaded: down, uploaded: up}
+	c.SendChoke()
+}
+
+// RemovePeer stops tracking a disconnected peer.
+func (m *ChokeManager) RemovePeer(c *client.Client) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.peers, c)
+}
+
+// Run starts the rechoke and optimistic-unchoke timers; it returns
+// immediately and runs until Stop is called.
+func (m *ChokeManager) Run() {
+	go m.rechokeLoop()
+	go m.optimisticLoop()
+}
+
+// Stop ends the background loops started by Run.
+func (m *ChokeManager) Stop() {
+	close(m.stop)
+}
+
+func (m *ChokeManager) rechokeLoop() {
+	ticker := time.NewTicker(rechokeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.rechoke()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *ChokeManager) optimisticLoop() {
+	ticker := time.NewTicker(optimisticInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.rotateOptimistic()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// rechoke unchokes the maxUnchoked peers with the highest rate since the
+// last rechoke and chokes everyone else, including a peer that
+// rotateOptimistic unchoked: that peer stays unchoked only until the next
+// rechoke unless it ranks in the top maxUnchoked. While leeching the rate that matters
+// is how fast a peer sends us data (tit-for-tat); while seeding it's how
+// fast we're able to send them data, since there's nothing left to
+// download from anyone.
+func (m *ChokeManager) rechoke() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	type ranked struct {
+		c    *client.Client
+		rate int64
+	}
+	ranked_ := make([]ranked, 0, len(m.peers))
+	for c, last := range m.peers {
+		down, up := c.TransferCounts()
+		var r int64
+		if m.seeding {
+			r = up - last.uploaded
+		} else {
+			r = down - last.downloaded
+		}
+		last.downloaded, last.uploaded = down, up
+		ranked_ = append(ranked_, ranked{c: c, rate: r})
+	}
+	sort.Slice(ranked_, func(i, j int) bool { return ranked_[i].rate > ranked_[j].rate })
+
+	for i, r := range ranked_ {
+		if i < maxUnchoked {
+			r.c.SendUnchoke()
+		} else {
+			r.c.SendChoke()
+		}
+	}
+}
+
+// rotateOptimistic unchokes a single random currently-choked peer, giving
+// peers outside the top maxUnchoked a chance to demonstrate a good rate and
+// earn a regular unchoke. It's skipped while seeding: with nothing left to
+// download, rechoke's top-uploaders ranking alone already cycles through
+// interested peers, so there's no discovery problem left for it to solve.
+func (m *ChokeManager) rotateOptimistic() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.seeding {
+		return
+	}
+
+	var choked []*client.Client
+	for c := range m.peers {
+		if c.AmChoking {
+			choked = append(choked, c)
+		}
+	}
+	if len(choked) == 0 {
+		return
+	}
+	chosen := choked[rand.Intn(len(choked))]
+	chosen.SendUnchoke()
+}
@@ -0,0 +1,76 @@
+// Description: Serves pieces of a completed download to whichever
+// connected peers we've unchoked, by answering their request messages
+// directly off disk.
+package seeder
+
+import (
+	"log"
+	"sync"
+
+	"bit-torrent/client"
+	"bit-torrent/peer2peer"
+	"bit-torrent/storage"
+)
+
+// SeedFile serves tor's content out of dir to clients until every one of
+// them disconnects. It expects the download to already be complete, e.g.
+// because DownloadToFile just returned successfully. Reading goes through
+// the same storage.FileStorage layout DownloadToFile wrote with, so a
+// multi-file torrent is served from the right physical file at the right
+// offset instead of assuming tor.Name names a single file.
+func SeedFile(clients []*client.Client, tor peer2peer.Torrent, dir string) {
+	store, err := storage.Open(dir, tor.Files, tor.PieceLength, tor.PieceHashes)
+	if err != nil {
+		log.Printf("seeder: cannot seed, could not open %s: %v\n", dir, err)
+		return
+	}
+	defer store.Close()
+
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c *client.Client) {
+			defer wg.Done()
+			serveClient(store, c)
+		}(c)
+	}
+	wg.Wait()
+}
+
+// serveClient answers c's requests until it disconnects; a not-interested
+// message does not end the loop.
+func serveClient(store storage.PieceStorage, c *client.Client) {
+	for {
+		msg, err := c.Read()
+		if err != nil {
+			return
+		}
+		if msg == nil {
+			continue // keep-alive
+		}
+
+		switch msg.ID {
+		case client.MsgInterested:
+			// ChokeManager decides when to unchoke; nothing to do here.
+		case client.MsgRequest:
+			index, begin, length, err := client.ParseRequest(msg)
+			if err != nil {
+				log.Printf("seeder: %s sent bad request: %v\n", c, err)
+				continue
+			}
+			if c.AmChoking {
+				continue
+			}
+			block := make([]byte, length)
+			if _, err := store.ReadAt(index, block, int64(begin)); err != nil {
+				log.Printf("seeder: failed to read piece #%d for %s: %v\n", index, c, err)
+				continue
+			}
+			if err := c.SendPiece(index, begin, block); err != nil {
+				return
+			}
+		case client.MsgNotInterested:
+			continue
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func piecesOf(t *testing.T, data []byte, pieceLength int) [][20]byte {
+	t.Helper()
+	var hashes [][20]byte
+	for off := 0; off < len(data); off += pieceLength {
+		end := off + pieceLength
+		if end > len(data) {
+			end = len(data)
+		}
+		hashes = append(hashes, sha1.Sum(data[off:end]))
+	}
+	return hashes
+}
+
+func TestOpenVerifiesExistingCompleteFile(t *testing.T) {
+	dir := t.TempDir()
+	const pieceLength = 4
+	data := []byte("abcdefgh") // two whole pieces
+	hashes := piecesOf(t, data, pieceLength)
+
+	if err := os.WriteFile(filepath.Join(dir, "file.bin"), data, 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	fs, err := Open(dir, []FileSpec{{Path: "file.bin", Length: len(data), Offset: 0}}, pieceLength, hashes)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer fs.Close()
+
+	for i, done := range fs.Completion() {
+		if !done {
+			t.Fatalf("expected piece %d to verify as complete on open", i)
+		}
+	}
+}
+
+func TestOpenDetectsIncompleteAndCorruptPieces(t *testing.T) {
+	dir := t.TempDir()
+	const pieceLength = 4
+	data := []byte("abcdefgh")
+	hashes := piecesOf(t, data, pieceLength)
+
+	// Corrupt the second piece before opening; the file is otherwise the
+	// right length (e.g. pre-allocated by a previous incomplete run).
+	corrupted := append([]byte{}, data...)
+	corrupted[4] = 'X'
+	if err := os.WriteFile(filepath.Join(dir, "file.bin"), corrupted, 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	fs, err := Open(dir, []FileSpec{{Path: "file.bin", Length: len(data), Offset: 0}}, pieceLength, hashes)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer fs.Close()
+
+	complete := fs.Completion()
+	if !complete[0] {
+		t.Fatal("expected untouched first piece to verify as complete")
+	}
+	if complete[1] {
+		t.Fatal("expected corrupted second piece to verify as incomplete")
+	}
+}
+
+func TestWriteAtThenMarkCompleteUpdatesCompletion(t *testing.T) {
+	dir := t.TempDir()
+	const pieceLength = 4
+	data := []byte("abcdefgh")
+	hashes := piecesOf(t, data, pieceLength)
+
+	// Fresh (empty) destination file: nothing verifies as complete yet.
+	fs, err := Open(dir, []FileSpec{{Path: "file.bin", Length: len(data), Offset: 0}}, pieceLength, hashes)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer fs.Close()
+
+	for i, done := range fs.Completion() {
+		if done {
+			t.Fatalf("expected piece %d to start incomplete on a fresh file", i)
+		}
+	}
+
+	if _, err := fs.WriteAt(0, data[:pieceLength], 0); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+	if err := fs.MarkComplete(0); err != nil {
+		t.Fatalf("MarkComplete: %v", err)
+	}
+
+	complete := fs.Completion()
+	if !complete[0] {
+		t.Fatal("expected piece 0 to be complete after WriteAt+MarkComplete")
+	}
+	if complete[1] {
+		t.Fatal("expected piece 1 to remain incomplete")
+	}
+
+	out := make([]byte, pieceLength)
+	if _, err := fs.ReadAt(0, out, 0); err != nil {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if string(out) != string(data[:pieceLength]) {
+		t.Fatalf("ReadAt got %q, want %q", out, data[:pieceLength])
+	}
+}
+
+func TestMultiFileWriteSpansFiles(t *testing.T) {
+	dir := t.TempDir()
+	const pieceLength = 6
+	specs := []FileSpec{
+		{Path: "a.bin", Length: 4, Offset: 0},
+		{Path: "b.bin", Length: 4, Offset: 4},
+	}
+	fs, err := Open(dir, specs, pieceLength, [][20]byte{{}})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer fs.Close()
+
+	piece := []byte("abcdef") // spans both 4-byte files
+	if _, err := fs.WriteAt(0, piece, 0); err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+
+	gotA, err := os.ReadFile(filepath.Join(dir, "a.bin"))
+	if err != nil {
+		t.Fatalf("ReadFile a.bin: %v", err)
+	}
+	gotB, err := os.ReadFile(filepath.Join(dir, "b.bin"))
+	if err != nil {
+		t.Fatalf("ReadFile b.bin: %v", err)
+	}
+	// b.bin is truncated to its full declared length up front, so its
+	// untouched tail stays zero-padded.
+	wantB := []byte("ef\x00\x00")
+	if string(gotA) != "abcd" || string(gotB) != string(wantB) {
+		t.Fatalf("got a.bin=%q b.bin=%q, want a.bin=%q b.bin=%q", gotA, gotB, "abcd", wantB)
+	}
+
+	out := make([]byte, len(piece))
+	if _, err := fs.ReadAt(0, out, 0); err != nil {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if string(out) != string(piece) {
+		t.Fatalf("ReadAt across files got %q, want %q", out, piece)
+	}
+}
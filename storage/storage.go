@@ -0,0 +1,25 @@
+// Package storage persists downloaded pieces to disk as they arrive,
+// instead of buffering the whole torrent in memory and writing it once at
+// the end, and lets a download resume after a crash by re-verifying
+// whatever is already on disk.
+package storage
+
+// PieceStorage is implemented by every storage backend. Pieces are
+// addressed by index; ReadAt/WriteAt offsets are relative to the start of
+// the piece, matching the block offsets already used in peer wire
+// messages.
+type PieceStorage interface {
+	ReadAt(pieceIndex int, p []byte, off int64) (int, error)
+	WriteAt(pieceIndex int, p []byte, off int64) (int, error)
+
+	// MarkComplete records that a piece has been fully written and passed
+	// its SHA-1 check, so Completion reflects it from then on.
+	MarkComplete(pieceIndex int) error
+
+	// Completion returns, for every piece, whether it is already on disk
+	// and verified. It is consulted once at startup to resume a download
+	// and thereafter to avoid re-requesting completed pieces.
+	Completion() []bool
+
+	Close() error
+}
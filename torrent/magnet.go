@@ -0,0 +1,571 @@
+// Description: Magnet URI parsing, the PeerSource abstraction that unifies
+// tracker/DHT/PEX peer discovery, and BEP 9 metadata-from-peers fetching for
+// torrents opened without a .torrent file.
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"bit-torrent/bencode"
+	"bit-torrent/client"
+	"bit-torrent/dht"
+	"bit-torrent/peers"
+)
+
+// magnetInfo holds the fields recoverable directly from a magnet URI, before
+// the full .torrent info dictionary has been fetched from peers.
+type magnetInfo struct {
+	infoHash  [20]byte
+	trackers  []string
+	name      string
+}
+
+// parseMagnetURI parses a `magnet:?xt=urn:btih:...&tr=...&dn=...` URI.
+func parseMagnetURI(uri string) (magnetInfo, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return magnetInfo{}, err
+	}
+	if u.Scheme != "magnet" {
+		return magnetInfo{}, fmt.Errorf("torrent: not a magnet URI: %s", uri)
+	}
+
+	q := u.Query()
+	xt := q.Get("xt")
+	const prefix = "urn:btih:"
+	if !strings.HasPrefix(xt, prefix) {
+		return magnetInfo{}, fmt.Errorf("torrent: magnet URI missing urn:btih xt param")
+	}
+	hashHex := strings.TrimPrefix(xt, prefix)
+
+	var infoHash [20]byte
+	if err := decodeInfoHash(hashHex, &infoHash); err != nil {
+		return magnetInfo{}, err
+	}
+
+	return magnetInfo{
+		infoHash: infoHash,
+		trackers: q["tr"],
+		name:     q.Get("dn"),
+	}, nil
+}
+
+// decodeInfoHash accepts either the usual 40-char hex encoding or the
+// (rarer) base32 encoding of a 20-byte infohash.
+func decodeInfoHash(s string, out *[20]byte) error {
+	if len(s) == 40 {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			return err
+		}
+		copy(out[:], b)
+		return nil
+	}
+	b, err := base32.StdEncoding.DecodeString(strings.ToUpper(s))
+	if err != nil {
+		return err
+	}
+	if len(b) != 20 {
+		return fmt.Errorf("torrent: decoded infohash has length %d, want 20", len(b))
+	}
+	copy(out[:], b)
+	return nil
+}
+
+// OpenMagnet opens a torrent from a magnet URI rather than a .torrent file.
+// Since a magnet URI carries only the infohash (and optional tracker
+// hints/display name), the returned TorrentFile has no PieceHashes or
+// PieceLength until GetTorrent fetches the info dictionary from peers over
+// BEP 9.
+func OpenMagnet(uri string) (TorrentFile, error) {
+	m, err := parseMagnetURI(uri)
+	if err != nil {
+		return TorrentFile{}, err
+	}
+	var announce string
+	if len(m.trackers) > 0 {
+		announce = m.trackers[0]
+	}
+	return TorrentFile{
+		Announce: announce,
+		InfoHash: m.infoHash,
+		Name:     m.name,
+	}, nil
+}
+
+// isMagnet reports whether t was created via OpenMagnet and therefore still
+// needs its piece metadata fetched from peers before it can be downloaded.
+func (t *TorrentFile) isMagnet() bool {
+	return len(t.PieceHashes) == 0
+}
+
+// PeerSource produces peers for a torrent. TorrentFile.requestPeers used to
+// talk to the HTTP tracker directly; it is now one implementation among
+// several (tracker, DHT, PEX) that can all feed the same swarm.
+type PeerSource interface {
+	RequestPeers() ([]peers.Peer, error)
+}
+
+// trackerPeerSource is the original tracker-announce peer source.
+type trackerPeerSource struct {
+	t      *TorrentFile
+	peerID [20]byte
+	port   uint16
+}
+
+func (s trackerPeerSource) RequestPeers() ([]peers.Peer, error) {
+	return s.t.requestPeers(s.peerID, s.port)
+}
+
+// dhtPeerSource finds peers for infoHash via the mainline DHT (BEP 5).
+// GetTorrent queries it alongside any tracker; for a magnet link without
+// trackers it is the only source.
+type dhtPeerSource struct {
+	node     *dht.DHT
+	infoHash [20]byte
+}
+
+func (s dhtPeerSource) RequestPeers() ([]peers.Peer, error) {
+	addrs, err := s.node.GetPeers(dht.ID(s.infoHash))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]peers.Peer, 0, len(addrs))
+	for _, a := range addrs {
+		out = append(out, peers.Peer{IP: a.IP, Port: uint16(a.Port)})
+	}
+	return out, nil
+}
+
+// pexPeerSource discovers peers via the Peer Exchange extension (BEP 11)
+// advertised by peers we're already connected to. Unlike the tracker and
+// DHT sources, it needs an existing swarm to piggyback on, so GetTorrent
+// (which runs before any connections exist) never constructs one; it's
+// meant for a caller that already holds clients, such as DiscoverPEXPeers
+// below, run after the initial connect to find bonus peers.
+type pexPeerSource struct {
+	clients  []*client.Client
+	peerID   [20]byte
+	infoHash [20]byte
+}
+
+func (s pexPeerSource) RequestPeers() ([]peers.Peer, error) {
+	peerCh := make(chan []peers.Peer, len(s.clients))
+	for _, c := range s.clients {
+		go func(c *client.Client) {
+			found, err := fetchPEXFromPeer(c.Peer(), s.peerID, s.infoHash)
+			if err != nil {
+				peerCh <- nil
+				return
+			}
+			peerCh <- found
+		}(c)
+	}
+
+	seen := make(map[string]bool)
+	var merged []peers.Peer
+	for range s.clients {
+		for _, p := range <-peerCh {
+			key := p.String()
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			merged = append(merged, p)
+		}
+	}
+	return merged, nil
+}
+
+// DiscoverPEXPeers asks every already-connected client for its ut_pex peer
+// list, via a fresh side connection to each (the long-lived client.Client
+// connections don't speak the extension protocol). It's meant to be called
+// once the initial swarm is up, to find peers the tracker and DHT missed.
+func DiscoverPEXPeers(clients []*client.Client, peerID, infoHash [20]byte) ([]peers.Peer, error) {
+	return pexPeerSource{clients: clients, peerID: peerID, infoHash: infoHash}.RequestPeers()
+}
+
+// requestPeersFromSources queries every source concurrently and merges the
+// results into a single deduplicated peer list, so a torrent with both a
+// tracker and DHT support isn't limited to whichever answers first.
+func requestPeersFromSources(sources []PeerSource) []peers.Peer {
+	peerCh := make(chan []peers.Peer, len(sources))
+	for _, src := range sources {
+		go func(src PeerSource) {
+			found, err := src.RequestPeers()
+			if err != nil {
+				peerCh <- nil
+				return
+			}
+			peerCh <- found
+		}(src)
+	}
+
+	seen := make(map[string]bool)
+	var merged []peers.Peer
+	for range sources {
+		for _, p := range <-peerCh {
+			key := fmt.Sprintf("%s:%d", p.IP, p.Port)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			merged = append(merged, p)
+		}
+	}
+	return merged
+}
+
+// fetchMetadataFromPeers implements BEP 9 (ut_metadata): it connects to
+// candidate peers, performs the BEP 10 extension handshake, requests each
+// metadata piece in turn, and validates the assembled info dictionary
+// against infoHash before returning it.
+func fetchMetadataFromPeers(peerList []peers.Peer, peerID [20]byte, infoHash [20]byte) (bencodeInfo, error) {
+	for _, p := range peerList {
+		info, err := fetchMetadataFromPeer(p, peerID, infoHash)
+		if err == nil {
+			return info, nil
+		}
+	}
+	return bencodeInfo{}, fmt.Errorf("torrent: no peer served metadata for infohash %x", infoHash)
+}
+
+// pstr is the protocol string sent in every BitTorrent handshake.
+const pstr = "BitTorrent protocol"
+
+// sendHandshake performs the standard BEP 3 handshake, setting the
+// extension-protocol reserved bit (BEP 10) so the peer knows to expect an
+// extension handshake next, and verifies the peer's infohash matches.
+func sendHandshake(conn net.Conn, infoHash, peerID [20]byte) error {
+	buf := make([]byte, 49+len(pstr))
+	buf[0] = byte(len(pstr))
+	curr := 1
+	curr += copy(buf[curr:], pstr)
+	curr += copy(buf[curr:], make([]byte, 8))
+	buf[curr-3] |= 0x10 // advertise support for the BEP 10 extension protocol
+	curr += copy(buf[curr:], infoHash[:])
+	copy(buf[curr:], peerID[:])
+
+	if _, err := conn.Write(buf); err != nil {
+		return err
+	}
+
+	resp := make([]byte, len(buf))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return err
+	}
+	var gotHash [20]byte
+	copy(gotHash[:], resp[1+len(pstr)+8:1+len(pstr)+8+20])
+	if !bytes.Equal(gotHash[:], infoHash[:]) {
+		return fmt.Errorf("torrent: peer returned mismatched infohash during handshake")
+	}
+	return nil
+}
+
+// extendedMessageID is the BitTorrent wire-protocol message ID (BEP 10)
+// shared by every extended message; the true type is carried inside the
+// message body as an extended-message ID byte.
+const extendedMessageID = 20
+
+// handshakeExtID is the reserved extended-message ID (0) used only for the
+// initial extension handshake.
+const handshakeExtID = 0
+
+// ourUTMetadataID is the extended-message ID we advertise for ut_metadata in
+// our own handshake dictionary; the peer echoes back its own assignment for
+// the same extension, which is what we must use when requesting pieces.
+const ourUTMetadataID = 1
+
+// fetchMetadataFromPeer dials p and performs the BEP 3 handshake, the BEP 10
+// extension handshake, and then fetches every ut_metadata (BEP 9) piece,
+// reassembling and SHA-1-validating the info dictionary.
+func fetchMetadataFromPeer(p peers.Peer, peerID [20]byte, infoHash [20]byte) (bencodeInfo, error) {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", p.IP, p.Port), 5*time.Second)
+	if err != nil {
+		return bencodeInfo{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(15 * time.Second))
+
+	if err := sendHandshake(conn, infoHash, peerID); err != nil {
+		return bencodeInfo{}, err
+	}
+
+	peerMetadataID, metadataSize, err := sendExtensionHandshake(conn)
+	if err != nil {
+		return bencodeInfo{}, err
+	}
+
+	numPieces := (metadataSize + metadataPieceSize - 1) / metadataPieceSize
+	raw := make([]byte, 0, metadataSize)
+	for i := 0; i < numPieces; i++ {
+		piece, err := requestMetadataPiece(conn, peerMetadataID, i)
+		if err != nil {
+			return bencodeInfo{}, err
+		}
+		raw = append(raw, piece...)
+	}
+	raw = raw[:metadataSize]
+
+	sum := sha1.Sum(raw)
+	if !bytes.Equal(sum[:], infoHash[:]) {
+		return bencodeInfo{}, fmt.Errorf("torrent: metadata from %s failed infohash check", p.IP)
+	}
+
+	var info bencodeInfo
+	if err := bencode.Unmarshal(bytes.NewReader(raw), &info); err != nil {
+		return bencodeInfo{}, err
+	}
+	return info, nil
+}
+
+// ourUTPexID is the extended-message ID we advertise for ut_pex (BEP 11) in
+// our own handshake dictionary.
+const ourUTPexID = 2
+
+// pexMsg is the ut_pex message body: a compact peer list of newly-seen
+// peers. BEP 11 also defines "added.f" flags and a "dropped" list; neither
+// is needed just to discover bonus peers.
+type pexMsg struct {
+	Added string `bencode:"added"`
+}
+
+// fetchPEXFromPeer dials p on a throwaway connection, advertises ut_pex
+// during the extension handshake, and waits for the peer's first ut_pex
+// message. Unlike ut_metadata, BEP 11 has no request message: peers push
+// ut_pex unprompted, typically within a few seconds of the handshake, so
+// this simply waits for one before the connection's deadline expires.
+func fetchPEXFromPeer(p peers.Peer, peerID, infoHash [20]byte) ([]peers.Peer, error) {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", p.IP, p.Port), 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if err := sendHandshake(conn, infoHash, peerID); err != nil {
+		return nil, err
+	}
+
+	out := extensionHandshakeDict{M: map[string]int{"ut_pex": ourUTPexID}}
+	var body bytes.Buffer
+	if err := bencode.Marshal(&body, out); err != nil {
+		return nil, err
+	}
+	if err := writeExtendedMessage(conn, handshakeExtID, body.Bytes()); err != nil {
+		return nil, err
+	}
+
+	extID, payload, err := readExtendedMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+	if extID != handshakeExtID {
+		return nil, fmt.Errorf("torrent: expected extension handshake, got ext id %d", extID)
+	}
+	var in extensionHandshakeDict
+	if err := bencode.Unmarshal(bytes.NewReader(payload), &in); err != nil {
+		return nil, err
+	}
+	if _, ok := in.M["ut_pex"]; !ok {
+		return nil, fmt.Errorf("torrent: peer does not support ut_pex")
+	}
+
+	for {
+		gotExtID, payload, err := readExtendedMessage(conn)
+		if err != nil {
+			return nil, err
+		}
+		if gotExtID != ourUTPexID {
+			continue
+		}
+		var msg pexMsg
+		if err := bencode.Unmarshal(bytes.NewReader(payload), &msg); err != nil {
+			return nil, err
+		}
+		return peers.Unmarshal([]byte(msg.Added))
+	}
+}
+
+// metadataPieceSize is the fixed 16KiB chunk size BEP 9 splits the info
+// dictionary into.
+const metadataPieceSize = 16 * 1024
+
+// extensionHandshakeDict is the `m` dictionary sent/received during the
+// BEP 10 handshake, plus the `metadata_size` BEP 9 adds once it's known.
+type extensionHandshakeDict struct {
+	M            map[string]int `bencode:"m"`
+	MetadataSize int            `bencode:"metadata_size"`
+}
+
+// sendExtensionHandshake advertises our support for ut_metadata and reads
+// back the peer's own handshake, returning the extended-message ID the
+// peer uses for ut_metadata and the advertised size of the info dictionary.
+func sendExtensionHandshake(conn net.Conn) (peerMetadataID int, metadataSize int, err error) {
+	out := extensionHandshakeDict{M: map[string]int{"ut_metadata": ourUTMetadataID}}
+	var body bytes.Buffer
+	if err := bencode.Marshal(&body, out); err != nil {
+		return 0, 0, err
+	}
+	if err := writeExtendedMessage(conn, handshakeExtID, body.Bytes()); err != nil {
+		return 0, 0, err
+	}
+
+	extID, payload, err := readExtendedMessage(conn)
+	if err != nil {
+		return 0, 0, err
+	}
+	if extID != handshakeExtID {
+		return 0, 0, fmt.Errorf("torrent: expected extension handshake, got ext id %d", extID)
+	}
+	var in extensionHandshakeDict
+	if err := bencode.Unmarshal(bytes.NewReader(payload), &in); err != nil {
+		return 0, 0, err
+	}
+	id, ok := in.M["ut_metadata"]
+	if !ok {
+		return 0, 0, fmt.Errorf("torrent: peer does not support ut_metadata")
+	}
+	if in.MetadataSize == 0 {
+		return 0, 0, fmt.Errorf("torrent: peer did not advertise metadata_size")
+	}
+	return id, in.MetadataSize, nil
+}
+
+// metadataMsg is the ut_metadata message dict defined by BEP 9, used for
+// both requests and replies; `msg_type` 0 is a request, 1 is a data reply carrying
+// the piece bytes immediately after the bencoded dict.
+type metadataMsg struct {
+	MsgType int `bencode:"msg_type"`
+	Piece   int `bencode:"piece"`
+}
+
+func requestMetadataPiece(conn net.Conn, peerMetadataID, piece int) ([]byte, error) {
+	var body bytes.Buffer
+	if err := bencode.Marshal(&body, metadataMsg{MsgType: 0, Piece: piece}); err != nil {
+		return nil, err
+	}
+	if err := writeExtendedMessage(conn, byte(peerMetadataID), body.Bytes()); err != nil {
+		return nil, err
+	}
+
+	extID, payload, err := readExtendedMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+	if extID != ourUTMetadataID {
+		return nil, fmt.Errorf("torrent: unexpected ext id %d in metadata reply", extID)
+	}
+	// The bencoded dict is a prefix of payload; the remaining bytes are the
+	// raw piece data, so we must find where the dict ends before decoding.
+	dictEnd, err := bencodeDictLen(payload)
+	if err != nil {
+		return nil, err
+	}
+	var reply metadataMsg
+	if err := bencode.Unmarshal(bytes.NewReader(payload[:dictEnd]), &reply); err != nil {
+		return nil, err
+	}
+	if reply.MsgType != 1 {
+		return nil, fmt.Errorf("torrent: peer rejected metadata piece %d", piece)
+	}
+	return payload[dictEnd:], nil
+}
+
+// bencodeDictLen scans a single top-level bencoded value (here always a
+// dict) at the start of b and returns its encoded length, so trailing raw
+// bytes (the ut_metadata piece payload) can be separated from it.
+func bencodeDictLen(b []byte) (int, error) {
+	var scan func(i int) (int, error)
+	scan = func(i int) (int, error) {
+		if i >= len(b) {
+			return 0, fmt.Errorf("torrent: truncated bencode value")
+		}
+		switch {
+		case b[i] == 'i':
+			j := bytes.IndexByte(b[i:], 'e')
+			if j < 0 {
+				return 0, fmt.Errorf("torrent: unterminated integer")
+			}
+			return i + j + 1, nil
+		case b[i] == 'l' || b[i] == 'd':
+			j := i + 1
+			for j < len(b) && b[j] != 'e' {
+				if b[i] == 'd' {
+					// dict keys are always strings
+					var err error
+					j, err = scan(j)
+					if err != nil {
+						return 0, err
+					}
+				}
+				var err error
+				j, err = scan(j)
+				if err != nil {
+					return 0, err
+				}
+			}
+			if j >= len(b) {
+				return 0, fmt.Errorf("torrent: unterminated list/dict")
+			}
+			return j + 1, nil
+		case b[i] >= '0' && b[i] <= '9':
+			colon := bytes.IndexByte(b[i:], ':')
+			if colon < 0 {
+				return 0, fmt.Errorf("torrent: malformed bencode string length")
+			}
+			n := 0
+			for _, c := range b[i : i+colon] {
+				n = n*10 + int(c-'0')
+			}
+			start := i + colon + 1
+			return start + n, nil
+		default:
+			return 0, fmt.Errorf("torrent: unexpected bencode token %q", b[i])
+		}
+	}
+	return scan(0)
+}
+
+// writeExtendedMessage frames an extended message as BEP 10 requires:
+// a 4-byte length prefix, the message ID (20), the extended-message ID,
+// then the payload.
+func writeExtendedMessage(w io.Writer, extID byte, payload []byte) error {
+	length := uint32(2 + len(payload))
+	buf := make([]byte, 4+length)
+	binary.BigEndian.PutUint32(buf[0:4], length)
+	buf[4] = extendedMessageID
+	buf[5] = extID
+	copy(buf[6:], payload)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readExtendedMessage(r io.Reader) (extID byte, payload []byte, err error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(lenBuf[:])
+	if length < 2 {
+		return 0, nil, fmt.Errorf("torrent: extended message too short")
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, nil, err
+	}
+	if buf[0] != extendedMessageID {
+		return 0, nil, fmt.Errorf("torrent: expected extended message id %d, got %d", extendedMessageID, buf[0])
+	}
+	return buf[1], buf[2:], nil
+}
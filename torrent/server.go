@@ -0,0 +1,190 @@
+// Description: An inbound TCP listener so other peers can connect to us,
+// not just the other way around, plus the announce lifecycle events (BEP 3
+// started/completed/stopped) that go along with being reachable.
+package torrent
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+
+	"bit-torrent/client"
+	"bit-torrent/peers"
+	"bit-torrent/tracker"
+)
+
+// Server accepts inbound peer connections on Port and hands each one, once
+// its handshake has been validated against a registered infohash, to the
+// registered torrent's swarm.
+type Server struct {
+	listener *net.TCPListener
+	peerID   [20]byte
+
+	mu       sync.Mutex
+	torrents map[[20]byte]chan *client.Client // infohash -> channel of newly connected peers
+}
+
+// NewServer starts listening on port for inbound peer connections.
+func NewServer(port uint16, peerID [20]byte) (*Server, error) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{Port: int(port)})
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		listener: l,
+		peerID:   peerID,
+		torrents: make(map[[20]byte]chan *client.Client),
+	}, nil
+}
+
+// Port returns the port we're actually listening on, for advertising to
+// trackers via the announce request so they report us as reachable.
+func (s *Server) Port() uint16 {
+	return uint16(s.listener.Addr().(*net.TCPAddr).Port)
+}
+
+// Register makes infoHash acceptable to incoming handshakes and returns the
+// channel new peers for that torrent arrive on.
+func (s *Server) Register(infoHash [20]byte) <-chan *client.Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch := make(chan *client.Client, 16)
+	s.torrents[infoHash] = ch
+	return ch
+}
+
+// Unregister stops accepting handshakes for infoHash, e.g. once a torrent
+// is removed.
+func (s *Server) Unregister(infoHash [20]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.torrents, infoHash)
+}
+
+// Serve accepts connections until the listener is closed.
+func (s *Server) Serve() error {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleConn(conn)
+	}
+}
+
+// Close stops accepting new connections.
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	infoHash, peerID, err := readHandshake(conn)
+	if err != nil {
+		log.Printf("server: bad handshake from %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	s.mu.Lock()
+	ch, ok := s.torrents[infoHash]
+	s.mu.Unlock()
+	if !ok {
+		log.Printf("server: %s requested unknown infohash %x, dropping", conn.RemoteAddr(), infoHash)
+		conn.Close()
+		return
+	}
+
+	if err := writeHandshake(conn, infoHash, s.peerID); err != nil {
+		log.Printf("server: failed to reply to %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	host, portStr, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		conn.Close()
+		return
+	}
+	p := peers.Peer{IP: net.ParseIP(host)}
+	fmt.Sscanf(portStr, "%d", &p.Port)
+
+	c, err := client.NewFromConn(conn, peerID, infoHash, p)
+	if err != nil {
+		log.Printf("server: could not wrap connection from %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	ch <- c
+}
+
+// readHandshake reads an inbound BEP 3 handshake, returning the infohash it
+// claims and the remote peer ID; the caller checks the infohash.
+func readHandshake(r io.Reader) (infoHash, peerID [20]byte, err error) {
+	lenBuf := make([]byte, 1)
+	if _, err = io.ReadFull(r, lenBuf); err != nil {
+		return
+	}
+	rest := make([]byte, 48+int(lenBuf[0]))
+	if _, err = io.ReadFull(r, rest); err != nil {
+		return
+	}
+	copy(infoHash[:], rest[8+int(lenBuf[0]):28+int(lenBuf[0])])
+	copy(peerID[:], rest[28+int(lenBuf[0]):48+int(lenBuf[0])])
+	return
+}
+
+// writeHandshake sends our half of the handshake back to an inbound peer.
+func writeHandshake(w io.Writer, infoHash, ourPeerID [20]byte) error {
+	buf := make([]byte, 49+len(pstr))
+	buf[0] = byte(len(pstr))
+	curr := 1
+	curr += copy(buf[curr:], pstr)
+	curr += copy(buf[curr:], make([]byte, 8))
+	curr += copy(buf[curr:], infoHash[:])
+	copy(buf[curr:], ourPeerID[:])
+	_, err := w.Write(buf)
+	return err
+}
+
+// NewPeerID generates a random 20-byte peer ID for this run of the client.
+func NewPeerID() ([20]byte, error) {
+	var id [20]byte
+	_, err := rand.Read(id[:])
+	return id, err
+}
+
+// AnnounceCompleted tells the torrent's trackers that the download has
+// finished, per BEP 3's event=completed. Like every announce it goes through
+// tracker.AnnounceTiers, so only the first tracker that answers is told.
+func (t *TorrentFile) AnnounceCompleted(peerID [20]byte, port uint16) error {
+	return t.announceEvent(peerID, port, tracker.EventCompleted)
+}
+
+// AnnounceStopped tells the torrent's trackers (the first one that answers,
+// as with AnnounceCompleted) that we're leaving the swarm, per BEP 3's
+// event=stopped. Callers should send this as part of a clean
+// shutdown so trackers don't keep counting us as an active peer.
+func (t *TorrentFile) AnnounceStopped(peerID [20]byte, port uint16) error {
+	return t.announceEvent(peerID, port, tracker.EventStopped)
+}
+
+func (t *TorrentFile) announceEvent(peerID [20]byte, port uint16, event tracker.Event) error {
+	tiers := t.AnnounceList
+	if len(tiers) == 0 {
+		if t.Announce == "" {
+			return nil // magnet/DHT-only torrent with no tracker to tell
+		}
+		tiers = [][]string{{t.Announce}}
+	}
+	_, err := tracker.AnnounceTiers(tiers, tracker.AnnounceRequest{
+		InfoHash: t.InfoHash,
+		PeerID:   peerID,
+		Port:     port,
+		Left:     int64(t.Length),
+		Event:    event,
+	})
+	return err
+}
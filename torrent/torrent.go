@@ -8,13 +8,16 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"bit-torrent/bencode"
 	"bit-torrent/client"
+	"bit-torrent/dht"
 	"bit-torrent/peer2peer"
 	"bit-torrent/peers"
+	"bit-torrent/storage"
 )
 
 // Port to listen on
@@ -22,28 +25,49 @@ const Port uint16 = 6881
 
 // TorrentFile encodes the metadata from a .torrent file
 type TorrentFile struct {
-	Announce    string
-	InfoHash    [20]byte
-	PieceHashes [][20]byte
-	PieceLength int
-	Length      int
-	Name        string
+	Announce     string
+	AnnounceList [][]string // BEP 12 multi-tracker tiers, Announce repeated as the sole entry of tier 0 if absent
+	InfoHash     [20]byte
+	PieceHashes  [][20]byte
+	PieceLength  int
+	Length       int
+	Name         string
+	Files        []FileInfo // multi-file torrents only; empty for single-file torrents
+}
+
+// FileInfo describes one physical file within a multi-file torrent and its
+// byte offset into the concatenated piece stream, so a storage backend can
+// map piece/offset pairs onto the right file.
+type FileInfo struct {
+	Path   string // relative path, joined with os.PathSeparator
+	Length int
+	Offset int64
+}
+
+type bencodeFileEntry struct {
+	Length int      `bencode:"length"`
+	Path   []string `bencode:"path"`
 }
 
 type bencodeInfo struct {
-	Pieces      string `bencode:"pieces"`
-	PieceLength int    `bencode:"piece length"`
-	Length      int    `bencode:"length"`
-	Name        string `bencode:"name"`
+	Pieces      string             `bencode:"pieces"`
+	PieceLength int                `bencode:"piece length"`
+	Length      int                `bencode:"length,omitempty"`
+	Name        string             `bencode:"name"`
+	Files       []bencodeFileEntry `bencode:"files,omitempty"`
 }
 
 type bencodeTorrent struct {
-	Announce string      `bencode:"announce"`
-	Info     bencodeInfo `bencode:"info"`
+	Announce     string       `bencode:"announce"`
+	AnnounceList [][]string   `bencode:"announce-list,omitempty"`
+	Info         bencodeInfo  `bencode:"info"`
 }
 
 // ParseTorrentFile parses a .torrent file and returns a TorrentFile struct
-// GetTorrent returns a Torrent struct from the TorrentFile struct
+// GetTorrent returns a Torrent struct from the TorrentFile struct. For a
+// torrent opened via OpenMagnet, it first discovers peers through the
+// tracker and DHT PeerSources (PEX is not used here) and uses them to fetch the info
+// dictionary over BEP 9 before a Torrent can be assembled.
 func (t *TorrentFile) GetTorrent() (peer2peer.Torrent, error) {
 	var peerID [20]byte
 	_, err := rand.Read(peerID[:])
@@ -52,9 +76,38 @@ func (t *TorrentFile) GetTorrent() (peer2peer.Torrent, error) {
 		return peer2peer.Torrent{}, err
 	}
 
-	peers, err := t.requestPeers(peerID, Port)
+	var sources []PeerSource
+	if t.Announce != "" {
+		sources = append(sources, trackerPeerSource{t: t, peerID: peerID, port: Port})
+	}
+
+	node, err := dht.New()
 	if err != nil {
-		return peer2peer.Torrent{}, err
+		log.Printf("dht: failed to start node, falling back to tracker only: %v", err)
+	} else {
+		sources = append(sources, dhtPeerSource{node: node, infoHash: t.InfoHash})
+	}
+
+	peers := requestPeersFromSources(sources)
+	if len(peers) == 0 {
+		return peer2peer.Torrent{}, fmt.Errorf("torrent: failed to discover any peers")
+	}
+
+	if t.isMagnet() {
+		info, err := fetchMetadataFromPeers(peers, peerID, t.InfoHash)
+		if err != nil {
+			return peer2peer.Torrent{}, err
+		}
+		pieceHashes, err := info.splitPieceHashes()
+		if err != nil {
+			return peer2peer.Torrent{}, err
+		}
+		t.PieceHashes = pieceHashes
+		t.PieceLength = info.PieceLength
+		t.Length = info.Length
+		if t.Name == "" {
+			t.Name = info.Name
+		}
 	}
 
 	torrent := peer2peer.Torrent{
@@ -65,6 +118,7 @@ func (t *TorrentFile) GetTorrent() (peer2peer.Torrent, error) {
 		PieceLength: t.PieceLength,
 		Length:      t.Length,
 		Name:        t.Name,
+		Files:       t.storageFiles(),
 	}
 
 	return torrent, nil
@@ -113,7 +167,7 @@ func ConnectToPeers(torrent peer2peer.Torrent,
 		wg.Add(1)
 		go func(p peers.Peer) {
 			defer wg.Done()
-			c, err := client.New(p, torrent.PeerID, torrent.InfoHash)
+			c, err := client.New(p, torrent.PeerID, torrent.InfoHash, client.Config{})
 			if err != nil {
 				log.Printf("Could not handshake with %s. Disconnecting\n", p.IP)
 				return
@@ -145,22 +199,34 @@ func ConnectToPeers(torrent peer2peer.Torrent,
 	return clients, nil
 }
 
-// DownloadToFile downloads the torrent file and saves it to the specified path
-func (t *TorrentFile) DownloadToFile(path string,
+// DownloadToFile downloads the torrent and saves it under the destination
+// directory dir (created if needed), resuming from whatever pieces already
+// match their SHA-1 hash on disk rather than always starting over. A
+// single-file torrent is written as dir/Name; a multi-file torrent spans
+// dir/<path> for each of its files. torrent.Download writes each piece to
+// store as soon as it arrives, so this never buffers the whole torrent in
+// memory.
+func (t *TorrentFile) DownloadToFile(dir string,
 	torrent peer2peer.Torrent, clients []*client.Client) error {
-	buf, err := torrent.Download(clients)
+	store, err := storage.Open(dir, t.storageFiles(), t.PieceLength, t.PieceHashes)
 	if err != nil {
 		return err
 	}
+	defer store.Close()
 
-	outFile, err := os.Create(path)
-	if err != nil {
-		return err
+	allDone := true
+	for _, done := range store.Completion() {
+		if !done {
+			allDone = false
+			break
+		}
+	}
+	if allDone {
+		fmt.Println("------------------------Already downloaded, nothing to resume-----------------------------------------")
+		return nil
 	}
-	defer outFile.Close()
 
-	_, err = outFile.Write(buf)
-	if err != nil {
+	if err := torrent.Download(clients, store); err != nil {
 		return err
 	}
 
@@ -168,6 +234,19 @@ func (t *TorrentFile) DownloadToFile(path string,
 	return nil
 }
 
+// storageFiles converts TorrentFile.Files into storage.FileSpec, falling
+// back to a single entry named after the torrent for single-file torrents.
+func (t *TorrentFile) storageFiles() []storage.FileSpec {
+	if len(t.Files) == 0 {
+		return []storage.FileSpec{{Path: t.Name, Length: t.Length, Offset: 0}}
+	}
+	specs := make([]storage.FileSpec, len(t.Files))
+	for i, f := range t.Files {
+		specs[i] = storage.FileSpec{Path: f.Path, Length: f.Length, Offset: f.Offset}
+	}
+	return specs
+}
+
 // Open parses a torrent file
 func Open(path string) (TorrentFile, error) {
 	file, err := os.Open(path)
@@ -224,15 +303,44 @@ func (bto *bencodeTorrent) toTorrentFile() (TorrentFile, error) {
 	if err != nil {
 		return TorrentFile{}, err
 	}
+	announceList := bto.AnnounceList
+	if len(announceList) == 0 && bto.Announce != "" {
+		announceList = [][]string{{bto.Announce}}
+	}
+
+	files, totalLength := bto.Info.fileList()
+
 	t := TorrentFile{
-		Announce:    bto.Announce,
-		InfoHash:    infoHash,
-		PieceHashes: pieceHashes,
-		PieceLength: bto.Info.PieceLength,
-		Length:      bto.Info.Length,
-		Name:        bto.Info.Name,
+		Announce:     bto.Announce,
+		AnnounceList: announceList,
+		InfoHash:     infoHash,
+		PieceHashes:  pieceHashes,
+		PieceLength:  bto.Info.PieceLength,
+		Length:       totalLength,
+		Name:         bto.Info.Name,
+		Files:        files,
 	}
 	return t, nil
 }
 
+// fileList returns the per-file layout and total length of the torrent. For
+// a single-file torrent it returns no entries, only Length; storageFiles
+// synthesizes the one-entry list storage backends need.
+func (i *bencodeInfo) fileList() ([]FileInfo, int) {
+	if len(i.Files) == 0 {
+		return nil, i.Length
+	}
+	files := make([]FileInfo, 0, len(i.Files))
+	var offset int64
+	for _, f := range i.Files {
+		files = append(files, FileInfo{
+			Path:   filepath.Join(f.Path...),
+			Length: f.Length,
+			Offset: offset,
+		})
+		offset += int64(f.Length)
+	}
+	return files, int(offset)
+}
+
 
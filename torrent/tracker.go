@@ -1,61 +1,33 @@
-// Description: Tracker request and response handling.
+// Description: Tracker request and response handling. The actual HTTP/UDP
+// protocol work lives in the tracker subpackage; this file adapts it to
+// TorrentFile.
 package torrent
 
 import (
-	"net/http"
-	"net/url"
-	"strconv"
-	"time"
-
-	"bit-torrent/bencode"
 	"bit-torrent/peers"
+	"bit-torrent/tracker"
 )
 
-type bencodeTrackerResp struct {
-	Interval int    `bencode:"interval"`
-	Peers    string `bencode:"peers"`
-}
-
-// buildTrackerURL builds a tracker URL from the torrent file and peer information and returns it as a string.
-func (t *TorrentFile) buildTrackerURL(peerID [20]byte, port uint16) (string, error) {
-	base, err := url.Parse(t.Announce)
-	if err != nil {
-		return "", err
-	}
-	params := url.Values{
-		"info_hash": []string{string(t.InfoHash[:])},
-		"peer_id":   []string{string(peerID[:])},
-		"port":      []string{strconv.Itoa(int(port))},
-		"uploaded":  []string{"0"},
-		// "downloaded"   : []string{string(t.Length)},
-		"downloaded": []string{"0"},
-		"compact":    []string{"1"},
-		"left":       []string{"0"},
-		// "left":       []string{strconv.Itoa(t.Length)},
-	}
-	base.RawQuery = params.Encode()
-	return base.String(), nil
-}
-
-// requestPeers requests peers from the tracker and returns a slice of peers.
+// requestPeers announces to the torrent's tracker tiers (BEP 12) and
+// returns the peers it was given. If the torrent has no AnnounceList (e.g.
+// it was opened from a magnet link), Announce alone is tried.
 func (t *TorrentFile) requestPeers(peerID [20]byte, port uint16) ([]peers.Peer, error) {
-	url, err := t.buildTrackerURL(peerID, port)
-	if err != nil {
-		return nil, err
+	tiers := t.AnnounceList
+	if len(tiers) == 0 {
+		tiers = [][]string{{t.Announce}}
 	}
 
-	c := &http.Client{Timeout: 15 * time.Second}
-	resp, err := c.Get(url)
-	if err != nil {
-		return nil, err
+	req := tracker.AnnounceRequest{
+		InfoHash: t.InfoHash,
+		PeerID:   peerID,
+		Port:     port,
+		Left:     int64(t.Length),
+		Event:    tracker.EventStarted,
 	}
-	defer resp.Body.Close()
 
-	trackerResp := bencodeTrackerResp{}
-	err = bencode.Unmarshal(resp.Body, &trackerResp)
+	resp, err := tracker.AnnounceTiers(tiers, req)
 	if err != nil {
 		return nil, err
 	}
-
-	return peers.Unmarshal([]byte(trackerResp.Peers))
-}
\ No newline at end of file
+	return resp.Peers, nil
+}
@@ -0,0 +1,90 @@
+// Description: The HTTP tracker announce, moved here from
+// TorrentFile.requestPeers so it can sit behind the Announcer interface
+// alongside the UDP implementation. It now reports the request's real
+// uploaded/downloaded/left counts and lifecycle event.
+package tracker
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"bit-torrent/bencode"
+	"bit-torrent/peers"
+)
+
+type bencodeTrackerResp struct {
+	Interval int    `bencode:"interval"`
+	Peers    string `bencode:"peers"`
+}
+
+// httpAnnouncer announces over the classic HTTP(S) tracker protocol.
+type httpAnnouncer struct {
+	announceURL string
+}
+
+// NewHTTPAnnouncer returns an Announcer that speaks the HTTP tracker
+// protocol against announceURL.
+func NewHTTPAnnouncer(announceURL string) Announcer {
+	return &httpAnnouncer{announceURL: announceURL}
+}
+
+func (a *httpAnnouncer) Announce(req AnnounceRequest) (AnnounceResponse, error) {
+	u, err := a.buildURL(req)
+	if err != nil {
+		return AnnounceResponse{}, err
+	}
+
+	c := &http.Client{Timeout: 15 * time.Second}
+	resp, err := c.Get(u)
+	if err != nil {
+		return AnnounceResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	trackerResp := bencodeTrackerResp{}
+	if err := bencode.Unmarshal(resp.Body, &trackerResp); err != nil {
+		return AnnounceResponse{}, err
+	}
+
+	peerList, err := peers.Unmarshal([]byte(trackerResp.Peers))
+	if err != nil {
+		return AnnounceResponse{}, err
+	}
+	return AnnounceResponse{Interval: trackerResp.Interval, Peers: peerList}, nil
+}
+
+func (a *httpAnnouncer) buildURL(req AnnounceRequest) (string, error) {
+	base, err := url.Parse(a.announceURL)
+	if err != nil {
+		return "", err
+	}
+	params := url.Values{
+		"info_hash":  []string{string(req.InfoHash[:])},
+		"peer_id":    []string{string(req.PeerID[:])},
+		"port":       []string{strconv.Itoa(int(req.Port))},
+		"uploaded":   []string{strconv.FormatInt(req.Uploaded, 10)},
+		"downloaded": []string{strconv.FormatInt(req.Downloaded, 10)},
+		"left":       []string{strconv.FormatInt(req.Left, 10)},
+		"compact":    []string{"1"},
+	}
+	if ev := eventParam(req.Event); ev != "" {
+		params.Set("event", ev)
+	}
+	base.RawQuery = params.Encode()
+	return base.String(), nil
+}
+
+func eventParam(e Event) string {
+	switch e {
+	case EventStarted:
+		return "started"
+	case EventCompleted:
+		return "completed"
+	case EventStopped:
+		return "stopped"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,87 @@
+// Description: Defines the Announcer interface shared by the HTTP and UDP
+// tracker implementations and the AnnounceRequest/Response types they
+// exchange, so TorrentFile can announce to either protocol interchangeably.
+package tracker
+
+import (
+	"fmt"
+	"strings"
+
+	"bit-torrent/peers"
+)
+
+// Event is the lifecycle event reported with an announce, per BEP 3.
+type Event int
+
+const (
+	EventNone Event = iota
+	EventStarted
+	EventCompleted
+	EventStopped
+)
+
+// AnnounceRequest carries the parameters common to both the HTTP and UDP
+// announce protocols.
+type AnnounceRequest struct {
+	Announce   string
+	InfoHash   [20]byte
+	PeerID     [20]byte
+	Port       uint16
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+	Event      Event
+	NumWant    int32
+}
+
+// AnnounceResponse is the protocol-agnostic result of an announce.
+type AnnounceResponse struct {
+	Interval int
+	Peers    []peers.Peer
+}
+
+// Announcer is implemented by each tracker transport (HTTP, UDP).
+type Announcer interface {
+	Announce(req AnnounceRequest) (AnnounceResponse, error)
+}
+
+// New returns the Announcer appropriate for the scheme of announceURL, so
+// callers don't need to know whether a given tracker speaks HTTP(S) or UDP.
+func New(announceURL string) (Announcer, error) {
+	switch {
+	case strings.HasPrefix(announceURL, "udp://"):
+		return NewUDPAnnouncer(announceURL)
+	case strings.HasPrefix(announceURL, "http://"), strings.HasPrefix(announceURL, "https://"):
+		return NewHTTPAnnouncer(announceURL), nil
+	default:
+		return nil, fmt.Errorf("tracker: unsupported announce URL scheme: %s", announceURL)
+	}
+}
+
+// AnnounceTiers announces to each tier of a BEP 12 announce-list in order,
+// trying every tracker within a tier before giving up on it and moving to
+// the next tier, and promoting whichever tracker answers first to the front
+// of its tier for subsequent announces.
+func AnnounceTiers(tiers [][]string, req AnnounceRequest) (AnnounceResponse, error) {
+	var lastErr error
+	for _, tier := range tiers {
+		for i, url := range tier {
+			req.Announce = url
+			a, err := New(url)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			resp, err := a.Announce(req)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			if i != 0 {
+				tier[0], tier[i] = tier[i], tier[0]
+			}
+			return resp, nil
+		}
+	}
+	return AnnounceResponse{}, fmt.Errorf("tracker: all tiers failed, last error: %w", lastErr)
+}
@@ -0,0 +1,225 @@
+// Description: A BEP 15 UDP tracker client: connect handshake, announce,
+// and the exponential-backoff retry schedule the spec requires. Most public
+// trackers today only speak UDP, so this is the primary Announcer in
+// practice even though the HTTP implementation came first.
+package tracker
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"bit-torrent/peers"
+)
+
+// protocolMagic identifies a connect request, per BEP 15.
+const protocolMagic uint64 = 0x41727101980
+
+const (
+	actionConnect  uint32 = 0
+	actionAnnounce uint32 = 1
+	actionError    uint32 = 3
+)
+
+// connectionIDTTL is how long a connection ID remains valid before a new
+// connect handshake is required.
+const connectionIDTTL = 60 * time.Second
+
+// maxRetries bounds the 15*2^n backoff schedule at n=8, per spec.
+const maxRetries = 8
+
+type udpAnnouncer struct {
+	addr string
+
+	connID     uint64
+	connIDTime time.Time
+}
+
+// NewUDPAnnouncer returns an Announcer that speaks the BEP 15 UDP tracker
+// protocol against a "udp://host:port/..." announce URL.
+func NewUDPAnnouncer(announceURL string) (Announcer, error) {
+	u, err := parseUDPURL(announceURL)
+	if err != nil {
+		return nil, err
+	}
+	return &udpAnnouncer{addr: u}, nil
+}
+
+func parseUDPURL(announceURL string) (string, error) {
+	// "udp://host:port/announce" -> "host:port"; trackers commonly omit the
+	// path entirely, so we only need to strip the scheme and any path.
+	const prefix = "udp://"
+	if len(announceURL) <= len(prefix) || announceURL[:len(prefix)] != prefix {
+		return "", fmt.Errorf("tracker: not a udp announce URL: %s", announceURL)
+	}
+	rest := announceURL[len(prefix):]
+	for i, c := range rest {
+		if c == '/' {
+			rest = rest[:i]
+			break
+		}
+	}
+	return rest, nil
+}
+
+func (a *udpAnnouncer) Announce(req AnnounceRequest) (AnnounceResponse, error) {
+	raddr, err := net.ResolveUDPAddr("udp", a.addr)
+	if err != nil {
+		return AnnounceResponse{}, err
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return AnnounceResponse{}, err
+	}
+	defer conn.Close()
+
+	if err := a.ensureConnected(conn); err != nil {
+		return AnnounceResponse{}, err
+	}
+
+	return a.announce(conn, req)
+}
+
+// ensureConnected performs the connect handshake if we don't already hold a
+// connection ID that's still within its 60s validity window.
+func (a *udpAnnouncer) ensureConnected(conn *net.UDPConn) error {
+	if a.connID != 0 && time.Since(a.connIDTime) < connectionIDTTL {
+		return nil
+	}
+
+	txID, err := randomUint32()
+	if err != nil {
+		return err
+	}
+
+	req := make([]byte, 16)
+	binary.BigEndian.PutUint64(req[0:8], protocolMagic)
+	binary.BigEndian.PutUint32(req[8:12], actionConnect)
+	binary.BigEndian.PutUint32(req[12:16], txID)
+
+	resp, err := sendWithRetry(conn, req, 16)
+	if err != nil {
+		return err
+	}
+	if err := checkResponse(resp, actionConnect, txID); err != nil {
+		return err
+	}
+
+	a.connID = binary.BigEndian.Uint64(resp[8:16])
+	a.connIDTime = time.Now()
+	return nil
+}
+
+func (a *udpAnnouncer) announce(conn *net.UDPConn, req AnnounceRequest) (AnnounceResponse, error) {
+	txID, err := randomUint32()
+	if err != nil {
+		return AnnounceResponse{}, err
+	}
+	key, err := randomUint32()
+	if err != nil {
+		return AnnounceResponse{}, err
+	}
+
+	numWant := req.NumWant
+	if numWant == 0 {
+		numWant = -1
+	}
+
+	buf := make([]byte, 98)
+	binary.BigEndian.PutUint64(buf[0:8], a.connID)
+	binary.BigEndian.PutUint32(buf[8:12], actionAnnounce)
+	binary.BigEndian.PutUint32(buf[12:16], txID)
+	copy(buf[16:36], req.InfoHash[:])
+	copy(buf[36:56], req.PeerID[:])
+	binary.BigEndian.PutUint64(buf[56:64], uint64(req.Downloaded))
+	binary.BigEndian.PutUint64(buf[64:72], uint64(req.Left))
+	binary.BigEndian.PutUint64(buf[72:80], uint64(req.Uploaded))
+	binary.BigEndian.PutUint32(buf[80:84], udpEventCode(req.Event))
+	binary.BigEndian.PutUint32(buf[84:88], 0) // IP address: 0 = use the sender's
+	binary.BigEndian.PutUint32(buf[88:92], key)
+	binary.BigEndian.PutUint32(buf[92:96], uint32(numWant))
+	binary.BigEndian.PutUint16(buf[96:98], req.Port)
+
+	resp, err := sendWithRetry(conn, buf, 20)
+	if err != nil {
+		return AnnounceResponse{}, err
+	}
+	if err := checkResponse(resp, actionAnnounce, txID); err != nil {
+		return AnnounceResponse{}, err
+	}
+
+	interval := int(binary.BigEndian.Uint32(resp[8:12]))
+	compactPeers := resp[20:]
+	peerList, err := peers.Unmarshal(compactPeers)
+	if err != nil {
+		return AnnounceResponse{}, err
+	}
+	return AnnounceResponse{Interval: interval, Peers: peerList}, nil
+}
+
+func udpEventCode(e Event) uint32 {
+	switch e {
+	case EventCompleted:
+		return 1
+	case EventStarted:
+		return 2
+	case EventStopped:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// sendWithRetry implements BEP 15's required backoff: retransmit after
+// 15 * 2^n seconds, doubling up to n=8, giving up after that.
+func sendWithRetry(conn *net.UDPConn, packet []byte, minRespLen int) ([]byte, error) {
+	buf := make([]byte, 2048)
+	for n := 0; n <= maxRetries; n++ {
+		if _, err := conn.Write(packet); err != nil {
+			return nil, err
+		}
+		timeout := time.Duration(15*(1<<uint(n))) * time.Second
+		conn.SetReadDeadline(time.Now().Add(timeout))
+
+		read, err := conn.Read(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return nil, err
+		}
+		if read < minRespLen {
+			continue
+		}
+		return buf[:read], nil
+	}
+	return nil, fmt.Errorf("tracker: udp announce timed out after %d retries", maxRetries)
+}
+
+func checkResponse(resp []byte, wantAction uint32, wantTxID uint32) error {
+	if len(resp) < 8 {
+		return fmt.Errorf("tracker: udp response too short")
+	}
+	action := binary.BigEndian.Uint32(resp[0:4])
+	txID := binary.BigEndian.Uint32(resp[4:8])
+	if txID != wantTxID {
+		return fmt.Errorf("tracker: udp response transaction ID mismatch")
+	}
+	if action == actionError {
+		return fmt.Errorf("tracker: udp tracker returned error: %s", string(resp[8:]))
+	}
+	if action != wantAction {
+		return fmt.Errorf("tracker: udp response action mismatch: got %d, want %d", action, wantAction)
+	}
+	return nil
+}
+
+func randomUint32() (uint32, error) {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b[:]), nil
+}